@@ -0,0 +1,53 @@
+/**
+ * File: /clusterpatch_types.go
+ * Project: v1alpha1
+ * File Created: 17-10-2026 16:02:27
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// ClusterPatch is the Schema for the clusterpatches API. it is executed from
+// the operator namespace
+type ClusterPatch struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PatchSpec   `json:"spec,omitempty"`
+	Status PatchStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterPatchList contains a list of ClusterPatch
+type ClusterPatchList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterPatch `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterPatch{}, &ClusterPatchList{})
+}
@@ -0,0 +1,30 @@
+/**
+ * File: /patch_conversion.go
+ * Project: v1alpha1
+ * File Created: 17-10-2026 18:22:40
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+// v1alpha1 is the storage version that the other versions are converted to and from
+
+// Hub marks this type as a conversion hub.
+func (*Patch) Hub() {}
+
+// Hub marks this type as a conversion hub.
+func (*ClusterPatch) Hub() {}
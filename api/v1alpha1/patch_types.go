@@ -23,6 +23,7 @@ package v1alpha1
 
 import (
 	"gitlab.com/bitspur/rock8s/patch-operator/config"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -31,12 +32,46 @@ const PatchFinalizer = config.PatchGroup + "." + config.Domain + "/finalizer"
 type PatchType string
 
 const (
+	ApplyPatchType     PatchType = "apply"
 	JsonPatchType      PatchType = "json"
 	MergePatchType     PatchType = "merge"
 	ScriptPatchType    PatchType = "script"
 	StrategicPatchType PatchType = "strategic"
 )
 
+type PatchState string
+
+const (
+	AppliedPatchState PatchState = "Applied"
+	DryRunPatchState  PatchState = "DryRun"
+	FailedPatchState  PatchState = "Failed"
+	PendingPatchState PatchState = "Pending"
+	SkippedPatchState PatchState = "Skipped"
+	WaitingPatchState PatchState = "Waiting"
+)
+
+type RunState string
+
+const (
+	FailedRunState    RunState = "Failed"
+	RunningRunState   RunState = "Running"
+	SucceededRunState RunState = "Succeeded"
+)
+
+type ReconcileMode string
+
+const (
+	ContinuousReconcileMode ReconcileMode = "continuous"
+	OnceReconcileMode       ReconcileMode = "once"
+)
+
+type Executor string
+
+const (
+	InProcessExecutor Executor = "inProcess"
+	JobExecutor       Executor = "job"
+)
+
 // the desired state of the patch
 type PatchSpec struct {
 	// a list of patches to be applied in order
@@ -45,11 +80,143 @@ type PatchSpec struct {
 	// change epoch to force recalibration
 	Epoch string `json:"epoch,omitempty"`
 
-	// image used in the job
+	// image used in jobs with patches of type script
 	Image string `json:"image,omitempty"`
 
-	// service account name used in the job
+	// service account name used in the job. it is required for cluster patches
 	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// executor used to apply the patches (inProcess or job). the inProcess
+	// executor applies the patches directly from the operator, impersonating
+	// the service account, instead of creating a job
+	Executor Executor `json:"executor,omitempty"`
+
+	// reconcile mode (once or continuous). in continuous mode the targets are
+	// watched and the patches are applied again when a target drifts
+	ReconcileMode ReconcileMode `json:"reconcileMode,omitempty"`
+
+	// revert the changes made by the patches when the patch is deleted
+	RevertOnDelete bool `json:"revertOnDelete,omitempty"`
+
+	// run the patches with a server side dry run and report the changes they
+	// would make in the status instead of applying them
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// field manager used when patching the targets
+	FieldManager string `json:"fieldManager,omitempty"`
+
+	// take ownership of conflicting fields when using server side apply
+	Force bool `json:"force,omitempty"`
+
+	// cron schedule to apply the patches again on
+	Schedule string `json:"schedule,omitempty"`
+
+	// time zone of the schedule, defaults to UTC
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// interval to apply the patches again after, such as 1h or 30m
+	ReapplyInterval string `json:"reapplyInterval,omitempty"`
+
+	// retry failed patches instead of pausing until the patch is updated
+	RetryPolicy *PatchSpecRetryPolicy `json:"retryPolicy,omitempty"`
+
+	// patches that must succeed before the patches are applied. cluster
+	// patches can only depend on cluster patches without a namespace
+	DependsOn []NamespacedName `json:"dependsOn,omitempty"`
+
+	// number of configmaps with the logs of previous jobs to keep
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	LogsHistoryLimit *int32 `json:"logsHistoryLimit,omitempty"`
+
+	// number of successful runs to keep in the status along with their jobs
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=25
+	SuccessfulRunsHistoryLimit *int32 `json:"successfulRunsHistoryLimit,omitempty"`
+
+	// number of failed runs to keep in the status along with their jobs
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=25
+	FailedRunsHistoryLimit *int32 `json:"failedRunsHistoryLimit,omitempty"`
+
+	// pod template of the job merged strategically into the generated job
+	JobTemplate *PatchSpecJobTemplate `json:"jobTemplate,omitempty"`
+}
+
+// the pod template of the job. the fields are merged strategically into the
+// generated job
+type PatchSpecJobTemplate struct {
+	// annotations of the job pod
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// compute resources of the job container
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// node selector of the job pod
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// tolerations of the job pod
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// affinity of the job pod
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// secrets used to pull the image of the job
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// security context of the job pod
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+	// security context of the job container
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// additional environment variables of the job container
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// additional sources of environment variables of the job container
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+
+	// volumes of the job pod
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+
+	// volume mounts of the job container
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+
+	// priority class of the job pod
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// seconds the job may run before it is terminated
+	// +kubebuilder:validation:Minimum=1
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+}
+
+type PatchSpecRetryPolicy struct {
+	// maximum number of attempts, including the first attempt. 0 retries forever
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=0
+	MaxAttempts int32 `json:"maxAttempts,omitempty"`
+
+	// backoff before the first retry, doubled for every following retry
+	BackoffBase string `json:"backoffBase,omitempty"`
+
+	// maximum backoff between retries
+	BackoffMax string `json:"backoffMax,omitempty"`
+
+	// percentage of the backoff to randomly add or subtract
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Jitter int32 `json:"jitter,omitempty"`
+
+	// only retry jobs that failed with one of these exit codes. all failures
+	// are retried when empty
+	RetryOnExitCodes []int32 `json:"retryOnExitCodes,omitempty"`
 }
 
 // PatchStatus defines the observed state of Patch
@@ -71,6 +238,141 @@ type PatchStatus struct {
 
 	// pause until update
 	PauseUntilUpdate bool `json:"pauseUntilUpdate,omitempty"`
+
+	// the results of the patches by id
+	Patches []PatchStatusPatch `json:"patches,omitempty"`
+
+	// the resources matched by the patches, up to the first 100
+	Targets []PatchStatusTarget `json:"targets,omitempty"`
+
+	// the patches matched more resources than are listed in targets
+	TargetsTruncated bool `json:"targetsTruncated,omitempty"`
+
+	// deprecated: snapshots are stored in a secret owned by the patch. only
+	// read to migrate snapshots taken by earlier versions of the operator
+	Snapshots []PatchStatusSnapshot `json:"snapshots,omitempty"`
+
+	// last time the patches were applied again on schedule
+	LastScheduledTime *metav1.Time `json:"lastScheduledTime,omitempty"`
+
+	// next time the patches will be applied again on schedule
+	NextScheduledTime *metav1.Time `json:"nextScheduledTime,omitempty"`
+
+	// number of failed attempts to apply the patches
+	Attempts int32 `json:"attempts,omitempty"`
+
+	// next time the failed patches will be retried
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+
+	// last time the patches succeeded
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+
+	// the logs of the last job
+	Logs *PatchStatusLogs `json:"logs,omitempty"`
+
+	// the number of the last run. it keeps counting when old runs are pruned,
+	// so the jobs of new runs never reuse the name of an old job
+	LastRun int32 `json:"lastRun,omitempty"`
+
+	// the runs of the patches in jobs, oldest first
+	Runs []PatchStatusRun `json:"runs,omitempty"`
+}
+
+// a run of the patches in a job
+type PatchStatusRun struct {
+	// number of the run
+	Run int32 `json:"run"`
+
+	// name of the job of the run
+	JobName string `json:"jobName"`
+
+	// state of the run (Running, Succeeded, Failed)
+	State RunState `json:"state,omitempty"`
+
+	// time the run started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// time the run completed
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// duration of the run
+	Duration string `json:"duration,omitempty"`
+}
+
+// the logs of a job
+type PatchStatusLogs struct {
+	// termination message of the job container
+	TerminationMessage string `json:"terminationMessage,omitempty"`
+
+	// the last lines of the logs
+	Tail string `json:"tail,omitempty"`
+
+	// name of the configmap containing the full logs
+	ConfigMapName string `json:"configMapName,omitempty"`
+}
+
+// the result of a patch
+type PatchStatusPatch struct {
+	// id of the patch, or its index when it has no id
+	Id string `json:"id"`
+
+	// state of the patch (Pending, Waiting, Applied, Skipped, Failed, DryRun)
+	State PatchState `json:"state,omitempty"`
+
+	// the skipIf criteria that caused the patch to be skipped
+	Reason string `json:"reason,omitempty"`
+
+	// resource version of the target after it was patched
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+
+	// time the patch started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// time the patch completed
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// error from applying the patch
+	Error string `json:"error,omitempty"`
+}
+
+// the state of a resource before it was first patched
+type PatchStatusSnapshot struct {
+	ApiVersion string `json:"apiVersion,omitempty"`
+
+	Kind string `json:"kind"`
+
+	Name string `json:"name"`
+
+	Namespace string `json:"namespace,omitempty"`
+
+	// json merge patch that restores the fields changed by the patches
+	Revert string `json:"revert"`
+
+	// error from reverting the resource
+	Message string `json:"message,omitempty"`
+}
+
+// a resource matched by a patch
+type PatchStatusTarget struct {
+	// id of the patch that matched the resource
+	Id string `json:"id"`
+
+	ApiVersion string `json:"apiVersion,omitempty"`
+
+	Kind string `json:"kind"`
+
+	Name string `json:"name"`
+
+	Namespace string `json:"namespace,omitempty"`
+
+	// state of the patch on the resource (Pending, Applied, Skipped, Failed, DryRun)
+	State PatchState `json:"state,omitempty"`
+
+	// status message
+	Message string `json:"message,omitempty"`
+
+	// json merge patch of the changes a dry run of the patch would make to the resource
+	Diff string `json:"diff,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -98,7 +400,14 @@ type PatchList struct {
 // https://kubernetes.io/docs/tasks/manage-kubernetes-objects/update-api-object-kubectl-patch
 type PatchSpecPatch struct {
 	// the patch to apply
-	Patch string `json:"patch"`
+	Patch string `json:"patch,omitempty"`
+
+	// read the patch to apply from a configmap or secret
+	PatchFrom *PatchSpecPatchFrom `json:"patchFrom,omitempty"`
+
+	// values read from other resources that the patch is rendered with as a
+	// go template
+	Vars []PatchSpecPatchVar `json:"vars,omitempty"`
 
 	// the resource to patch
 	Target Target `json:"target"`
@@ -107,7 +416,7 @@ type PatchSpecPatch struct {
 	// https://kubernetes.io/docs/tasks/manage-kubernetes-objects/update-api-object-kubectl-patch/#use-a-json-merge-patch-to-update-a-deployment
 	Type PatchType `json:"type,omitempty"`
 
-	// wait for time in milliseconds before applying patch
+	// wait for time in seconds before applying patch
 	WaitForTimeout int `json:"waitForTimeout,omitempty"`
 
 	// wait for the resource to exist
@@ -118,6 +427,28 @@ type PatchSpecPatch struct {
 
 	// optional patch id for reference
 	Id string `json:"id,omitempty"`
+
+	// overrides the dry run setting of the patch for this patch
+	DryRun *bool `json:"dryRun,omitempty"`
+}
+
+type PatchSpecPatchFrom struct {
+	// selects a key of a configmap
+	ConfigMapKeyRef *KeyRef `json:"configMapKeyRef,omitempty"`
+
+	// selects a key of a secret
+	SecretKeyRef *KeyRef `json:"secretKeyRef,omitempty"`
+}
+
+type PatchSpecPatchVar struct {
+	// name of the var in the patch template
+	Name string `json:"name"`
+
+	// the resource to read the value from
+	Target Target `json:"target"`
+
+	// json path of the value
+	JsonPath string `json:"jsonPath"`
 }
 
 type PatchSpecPatchSkipIf struct {
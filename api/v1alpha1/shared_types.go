@@ -24,11 +24,14 @@
 
 package v1alpha1
 
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
 // Target locates a resource
 type Target struct {
 	Kind string `json:"kind"`
 
-	Name string `json:"name"`
+	// name of the resource. glob patterns like my-* match multiple resources
+	Name string `json:"name,omitempty"`
 
 	Namespace string `json:"namespace,omitempty"`
 
@@ -37,6 +40,13 @@ type Target struct {
 	Version string `json:"version,omitempty"`
 
 	ApiVersion string `json:"apiVersion,omitempty"`
+
+	// select the resources by label
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+
+	// select the namespaces of the resources by label. only used when no
+	// namespace is set
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
 }
 
 type NamespacedName struct {
@@ -47,6 +57,18 @@ type NamespacedName struct {
 	Namespace string `json:"namespace,omitempty"`
 }
 
+type KeyRef struct {
+	// name
+	Name string `json:"name"`
+
+	// key
+	Key string `json:"key"`
+
+	// namespace, only allowed to differ from the namespace of the patch when
+	// cross namespace references are enabled
+	Namespace string `json:"namespace,omitempty"`
+}
+
 type Phase string
 
 const (
@@ -55,4 +77,5 @@ const (
 	ReadyPhase     Phase = "Ready"
 	SucceededPhase Phase = "Succeeded"
 	UnknownPhase   Phase = "Unknown"
+	WaitingPhase   Phase = "Waiting"
 )
@@ -0,0 +1,515 @@
+/**
+ * File: /patch_conversion.go
+ * Project: v1beta1
+ * File Created: 17-10-2026 18:22:40
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1beta1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	"gitlab.com/bitspur/rock8s/patch-operator/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+	"sigs.k8s.io/yaml"
+)
+
+// OriginalSpecAnnotation keeps the parts of the v1alpha1 spec of a converted
+// object that are lost in the conversion
+const OriginalSpecAnnotation = config.PatchGroup + "." + config.Domain + "/v1alpha1-spec"
+
+// originalSpec is the value of the original spec annotation. patches are keyed
+// by their index
+type originalSpec struct {
+	Patches         map[string]v1alpha1.PatchSpecPatch `json:"patches,omitempty"`
+	ReapplyInterval string                             `json:"reapplyInterval,omitempty"`
+	BackoffBase     string                             `json:"backoffBase,omitempty"`
+	BackoffMax      string                             `json:"backoffMax,omitempty"`
+}
+
+// ConvertTo converts this Patch to the Hub version (v1alpha1).
+func (src *Patch) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.Patch)
+	original, err := convertMetaTo(&src.ObjectMeta, &dst.ObjectMeta)
+	if err != nil {
+		return err
+	}
+	if err := convertSpecTo(&src.Spec, &dst.Spec, original); err != nil {
+		return err
+	}
+	return convertStatus(&src.Status, &dst.Status)
+}
+
+// ConvertFrom converts from the Hub version (v1alpha1) to this version.
+func (dst *Patch) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.Patch)
+	if err := convertSpecFrom(&src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+	if err := convertMetaFrom(&src.ObjectMeta, &dst.ObjectMeta, &src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+	return convertStatus(&src.Status, &dst.Status)
+}
+
+// ConvertTo converts this ClusterPatch to the Hub version (v1alpha1).
+func (src *ClusterPatch) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.ClusterPatch)
+	original, err := convertMetaTo(&src.ObjectMeta, &dst.ObjectMeta)
+	if err != nil {
+		return err
+	}
+	if err := convertSpecTo(&src.Spec, &dst.Spec, original); err != nil {
+		return err
+	}
+	return convertStatus(&src.Status, &dst.Status)
+}
+
+// ConvertFrom converts from the Hub version (v1alpha1) to this version.
+func (dst *ClusterPatch) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.ClusterPatch)
+	if err := convertSpecFrom(&src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+	if err := convertMetaFrom(&src.ObjectMeta, &dst.ObjectMeta, &src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+	return convertStatus(&src.Status, &dst.Status)
+}
+
+// convertMetaFrom copies the metadata and keeps the patches and durations of
+// the v1alpha1 spec that do not survive the conversion in an annotation, so
+// they can be restored when the object is converted back
+func convertMetaFrom(src *metav1.ObjectMeta, dst *metav1.ObjectMeta, spec *v1alpha1.PatchSpec, converted *PatchSpec) error {
+	src.DeepCopyInto(dst)
+	if dst.Annotations != nil {
+		delete(dst.Annotations, OriginalSpecAnnotation)
+	}
+	original, err := lostSpec(spec, converted)
+	if err != nil {
+		return err
+	}
+	if original == nil {
+		return nil
+	}
+	body, err := json.Marshal(original)
+	if err != nil {
+		return err
+	}
+	if dst.Annotations == nil {
+		dst.Annotations = map[string]string{}
+	}
+	dst.Annotations[OriginalSpecAnnotation] = string(body)
+	return nil
+}
+
+// convertMetaTo copies the metadata without the original v1alpha1 spec and
+// returns it
+func convertMetaTo(src *metav1.ObjectMeta, dst *metav1.ObjectMeta) (*originalSpec, error) {
+	src.DeepCopyInto(dst)
+	body, ok := dst.Annotations[OriginalSpecAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	delete(dst.Annotations, OriginalSpecAnnotation)
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+	original := &originalSpec{}
+	if err := json.Unmarshal([]byte(body), original); err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid annotation %s: %s", OriginalSpecAnnotation, err.Error()))
+	}
+	return original, nil
+}
+
+// lostSpec finds the patches and durations of a v1alpha1 spec that are not the
+// same after converting it to v1beta1 and back. nil is returned when nothing is
+// lost
+func lostSpec(spec *v1alpha1.PatchSpec, converted *PatchSpec) (*originalSpec, error) {
+	back := &v1alpha1.PatchSpec{}
+	if err := convertSpecTo(converted, back, nil); err != nil {
+		return nil, err
+	}
+	original := &originalSpec{}
+	lost := false
+	for i := range spec.Patches {
+		if !reflect.DeepEqual(spec.Patches[i], back.Patches[i]) {
+			if original.Patches == nil {
+				original.Patches = map[string]v1alpha1.PatchSpecPatch{}
+			}
+			original.Patches[strconv.Itoa(i)] = spec.Patches[i]
+			lost = true
+		}
+	}
+	if spec.ReapplyInterval != back.ReapplyInterval {
+		original.ReapplyInterval = spec.ReapplyInterval
+		lost = true
+	}
+	if spec.RetryPolicy != nil {
+		if spec.RetryPolicy.BackoffBase != back.RetryPolicy.BackoffBase {
+			original.BackoffBase = spec.RetryPolicy.BackoffBase
+			lost = true
+		}
+		if spec.RetryPolicy.BackoffMax != back.RetryPolicy.BackoffMax {
+			original.BackoffMax = spec.RetryPolicy.BackoffMax
+			lost = true
+		}
+	}
+	if !lost {
+		return nil, nil
+	}
+	return original, nil
+}
+
+// convertSpecTo converts the spec to v1alpha1. patches and durations that were
+// not changed are restored from the original v1alpha1 spec, so the stored spec
+// stays the same after a round trip
+func convertSpecTo(src *PatchSpec, dst *v1alpha1.PatchSpec, original *originalSpec) error {
+	if original == nil {
+		original = &originalSpec{}
+	}
+	dst.Patches = nil
+	for i, srcPatch := range src.Patches {
+		if originalPatch, ok := original.Patches[strconv.Itoa(i)]; ok {
+			unchanged, err := equalPatch(&originalPatch, &srcPatch)
+			if err != nil {
+				return err
+			}
+			if unchanged {
+				dst.Patches = append(dst.Patches, originalPatch)
+				continue
+			}
+		}
+		dstPatch, err := convertPatchTo(i, &srcPatch)
+		if err != nil {
+			return err
+		}
+		dst.Patches = append(dst.Patches, dstPatch)
+	}
+	dst.Epoch = src.Epoch
+	dst.Image = src.Image
+	dst.ServiceAccountName = src.ServiceAccountName
+	dst.Executor = v1alpha1.Executor(src.Executor)
+	dst.ReconcileMode = v1alpha1.ReconcileMode(src.ReconcileMode)
+	dst.RevertOnDelete = src.RevertOnDelete
+	dst.DryRun = src.DryRun
+	dst.FieldManager = src.FieldManager
+	dst.Force = src.Force
+	dst.Schedule = src.Schedule
+	dst.TimeZone = src.TimeZone
+	dst.ReapplyInterval = restoreDuration(original.ReapplyInterval, src.ReapplyInterval)
+	dst.RetryPolicy = nil
+	if src.RetryPolicy != nil {
+		dst.RetryPolicy = &v1alpha1.PatchSpecRetryPolicy{
+			MaxAttempts:      src.RetryPolicy.MaxAttempts,
+			BackoffBase:      restoreDuration(original.BackoffBase, src.RetryPolicy.BackoffBase),
+			BackoffMax:       restoreDuration(original.BackoffMax, src.RetryPolicy.BackoffMax),
+			Jitter:           src.RetryPolicy.Jitter,
+			RetryOnExitCodes: src.RetryPolicy.RetryOnExitCodes,
+		}
+	}
+	dst.DependsOn = nil
+	for _, dependency := range src.DependsOn {
+		dst.DependsOn = append(dst.DependsOn, v1alpha1.NamespacedName(dependency))
+	}
+	dst.LogsHistoryLimit = src.LogsHistoryLimit
+	dst.JobTemplate = (*v1alpha1.PatchSpecJobTemplate)(src.JobTemplate)
+	dst.SuccessfulRunsHistoryLimit = src.SuccessfulRunsHistoryLimit
+	dst.FailedRunsHistoryLimit = src.FailedRunsHistoryLimit
+	return nil
+}
+
+func convertSpecFrom(src *v1alpha1.PatchSpec, dst *PatchSpec) error {
+	dst.Patches = nil
+	for _, srcPatch := range src.Patches {
+		dst.Patches = append(dst.Patches, convertPatchFrom(&srcPatch))
+	}
+	dst.Epoch = src.Epoch
+	dst.Image = src.Image
+	dst.ServiceAccountName = src.ServiceAccountName
+	dst.Executor = Executor(src.Executor)
+	dst.ReconcileMode = ReconcileMode(src.ReconcileMode)
+	dst.RevertOnDelete = src.RevertOnDelete
+	dst.DryRun = src.DryRun
+	dst.FieldManager = src.FieldManager
+	dst.Force = src.Force
+	dst.Schedule = src.Schedule
+	dst.TimeZone = src.TimeZone
+	reapplyInterval, err := parseDuration("reapplyInterval", src.ReapplyInterval)
+	if err != nil {
+		return err
+	}
+	dst.ReapplyInterval = reapplyInterval
+	dst.RetryPolicy = nil
+	if src.RetryPolicy != nil {
+		backoffBase, err := parseDuration("retryPolicy.backoffBase", src.RetryPolicy.BackoffBase)
+		if err != nil {
+			return err
+		}
+		backoffMax, err := parseDuration("retryPolicy.backoffMax", src.RetryPolicy.BackoffMax)
+		if err != nil {
+			return err
+		}
+		dst.RetryPolicy = &PatchSpecRetryPolicy{
+			MaxAttempts:      src.RetryPolicy.MaxAttempts,
+			BackoffBase:      backoffBase,
+			BackoffMax:       backoffMax,
+			Jitter:           src.RetryPolicy.Jitter,
+			RetryOnExitCodes: src.RetryPolicy.RetryOnExitCodes,
+		}
+	}
+	dst.DependsOn = nil
+	for _, dependency := range src.DependsOn {
+		dst.DependsOn = append(dst.DependsOn, NamespacedName(dependency))
+	}
+	dst.LogsHistoryLimit = src.LogsHistoryLimit
+	dst.JobTemplate = (*PatchSpecJobTemplate)(src.JobTemplate)
+	dst.SuccessfulRunsHistoryLimit = src.SuccessfulRunsHistoryLimit
+	dst.FailedRunsHistoryLimit = src.FailedRunsHistoryLimit
+	return nil
+}
+
+// convertPatchTo converts a patch to v1alpha1, which keeps the patch, template
+// and script in the same field, so only one of them can be set
+func convertPatchTo(index int, srcPatch *PatchSpecPatch) (v1alpha1.PatchSpecPatch, error) {
+	set := []string{}
+	if srcPatch.Patch != nil {
+		set = append(set, "patch")
+	}
+	if srcPatch.Template != "" {
+		set = append(set, "template")
+	}
+	if srcPatch.Script != "" {
+		set = append(set, "script")
+	}
+	if len(set) > 1 {
+		id := srcPatch.Id
+		if id == "" {
+			id = strconv.Itoa(index)
+		}
+		return v1alpha1.PatchSpecPatch{}, errors.New(fmt.Sprintf(
+			"patch %s sets %s but only one of patch, template and script can be set",
+			id,
+			strings.Join(set, " and "),
+		))
+	}
+	dstPatch := v1alpha1.PatchSpecPatch{
+		Target:          convertTargetTo(&srcPatch.Target),
+		Type:            v1alpha1.PatchType(srcPatch.Type),
+		WaitForResource: srcPatch.WaitForResource,
+		Id:              srcPatch.Id,
+		DryRun:          srcPatch.DryRun,
+	}
+	switch {
+	case srcPatch.Script != "":
+		dstPatch.Patch = srcPatch.Script
+	case srcPatch.Template != "":
+		dstPatch.Patch = srcPatch.Template
+	case srcPatch.Patch != nil:
+		dstPatch.Patch = string(srcPatch.Patch.Raw)
+	}
+	if srcPatch.PatchFrom != nil {
+		dstPatch.PatchFrom = &v1alpha1.PatchSpecPatchFrom{
+			ConfigMapKeyRef: (*v1alpha1.KeyRef)(srcPatch.PatchFrom.ConfigMapKeyRef),
+			SecretKeyRef:    (*v1alpha1.KeyRef)(srcPatch.PatchFrom.SecretKeyRef),
+		}
+	}
+	for _, srcVar := range srcPatch.Vars {
+		dstPatch.Vars = append(dstPatch.Vars, v1alpha1.PatchSpecPatchVar{
+			Name:     srcVar.Name,
+			Target:   convertTargetTo(&srcVar.Target),
+			JsonPath: srcVar.JsonPath,
+		})
+	}
+	if srcPatch.WaitForTimeout != nil {
+		// v1alpha1 waits in whole seconds
+		dstPatch.WaitForTimeout = int((srcPatch.WaitForTimeout.Duration + time.Second - 1) / time.Second)
+	}
+	for _, srcSkipIf := range srcPatch.SkipIf {
+		dstSkipIf := v1alpha1.PatchSpecPatchSkipIf{
+			JsonPath: srcSkipIf.JsonPath,
+			Regex:    srcSkipIf.Regex,
+		}
+		if srcSkipIf.Target != nil {
+			target := convertTargetTo(srcSkipIf.Target)
+			dstSkipIf.Target = &target
+		}
+		dstPatch.SkipIf = append(dstPatch.SkipIf, dstSkipIf)
+	}
+	return dstPatch, nil
+}
+
+func convertPatchFrom(srcPatch *v1alpha1.PatchSpecPatch) PatchSpecPatch {
+	dstPatch := PatchSpecPatch{
+		Target:          convertTargetFrom(&srcPatch.Target),
+		Type:            PatchType(srcPatch.Type),
+		WaitForResource: srcPatch.WaitForResource,
+		Id:              srcPatch.Id,
+		DryRun:          srcPatch.DryRun,
+	}
+	switch {
+	case srcPatch.Patch == "":
+	case srcPatch.Type == v1alpha1.ScriptPatchType:
+		dstPatch.Script = srcPatch.Patch
+	case len(srcPatch.Vars) > 0:
+		dstPatch.Template = srcPatch.Patch
+	default:
+		dstPatch.Patch, dstPatch.Template = structuredPatch(srcPatch.Patch)
+	}
+	if srcPatch.PatchFrom != nil {
+		dstPatch.PatchFrom = &PatchSpecPatchFrom{
+			ConfigMapKeyRef: (*KeyRef)(srcPatch.PatchFrom.ConfigMapKeyRef),
+			SecretKeyRef:    (*KeyRef)(srcPatch.PatchFrom.SecretKeyRef),
+		}
+	}
+	for _, srcVar := range srcPatch.Vars {
+		dstPatch.Vars = append(dstPatch.Vars, PatchSpecPatchVar{
+			Name:     srcVar.Name,
+			Target:   convertTargetFrom(&srcVar.Target),
+			JsonPath: srcVar.JsonPath,
+		})
+	}
+	if srcPatch.WaitForTimeout > 0 {
+		dstPatch.WaitForTimeout = &metav1.Duration{
+			Duration: time.Duration(srcPatch.WaitForTimeout) * time.Second,
+		}
+	}
+	for _, srcSkipIf := range srcPatch.SkipIf {
+		dstSkipIf := PatchSpecPatchSkipIf{
+			JsonPath: srcSkipIf.JsonPath,
+			Regex:    srcSkipIf.Regex,
+		}
+		if srcSkipIf.Target != nil {
+			target := convertTargetFrom(srcSkipIf.Target)
+			dstSkipIf.Target = &target
+		}
+		dstPatch.SkipIf = append(dstPatch.SkipIf, dstSkipIf)
+	}
+	return dstPatch
+}
+
+// equalPatch checks if a v1alpha1 patch converts to the same v1beta1 patch.
+// the patches are compared by their json values, since the api server may
+// reformat structured patches
+func equalPatch(original *v1alpha1.PatchSpecPatch, patch *PatchSpecPatch) (bool, error) {
+	converted := convertPatchFrom(original)
+	values := []interface{}{}
+	for _, item := range []*PatchSpecPatch{&converted, patch} {
+		body, err := json.Marshal(item)
+		if err != nil {
+			return false, err
+		}
+		var value interface{}
+		if err := json.Unmarshal(body, &value); err != nil {
+			return false, err
+		}
+		values = append(values, value)
+	}
+	return reflect.DeepEqual(values[0], values[1]), nil
+}
+
+// convertStatus converts between the statuses of the versions, which have the
+// same fields
+func convertStatus(src interface{}, dst interface{}) error {
+	body, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, dst)
+}
+
+func convertTargetTo(src *Target) v1alpha1.Target {
+	return v1alpha1.Target{
+		ApiVersion:        src.ApiVersion,
+		Kind:              src.Kind,
+		Name:              src.Name,
+		Namespace:         src.Namespace,
+		LabelSelector:     src.LabelSelector,
+		NamespaceSelector: src.NamespaceSelector,
+	}
+}
+
+// convertTargetFrom assembles the api version of the target from its group
+// and version when it is not set
+func convertTargetFrom(src *v1alpha1.Target) Target {
+	apiVersion := src.ApiVersion
+	if apiVersion == "" && src.Version != "" {
+		apiVersion = schema.GroupVersion{Group: src.Group, Version: src.Version}.String()
+	}
+	return Target{
+		ApiVersion:        apiVersion,
+		Kind:              src.Kind,
+		Name:              src.Name,
+		Namespace:         src.Namespace,
+		LabelSelector:     src.LabelSelector,
+		NamespaceSelector: src.NamespaceSelector,
+	}
+}
+
+// structuredPatch parses a patch into an object or a list of operations. the
+// patch is returned as a template when it is not structured
+func structuredPatch(patch string) (*runtime.RawExtension, string) {
+	body, err := yaml.YAMLToJSON([]byte(patch))
+	if err != nil {
+		return nil, patch
+	}
+	trimmed := strings.TrimSpace(string(body))
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return nil, patch
+	}
+	return &runtime.RawExtension{Raw: body}, ""
+}
+
+func parseDuration(field string, value string) (*metav1.Duration, error) {
+	if value == "" {
+		return nil, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid %s %s: %s", field, value, err.Error()))
+	}
+	return &metav1.Duration{Duration: duration}, nil
+}
+
+// restoreDuration formats a duration, keeping the original text when it is the
+// same duration
+func restoreDuration(original string, duration *metav1.Duration) string {
+	if duration != nil && original != "" {
+		if originalDuration, err := time.ParseDuration(original); err == nil && originalDuration == duration.Duration {
+			return original
+		}
+	}
+	return formatDuration(duration)
+}
+
+func formatDuration(duration *metav1.Duration) string {
+	if duration == nil {
+		return ""
+	}
+	return duration.Duration.String()
+}
@@ -0,0 +1,411 @@
+/**
+ * File: /patch_conversion_test.go
+ * Project: v1beta1
+ * File Created: 17-10-2026 06:33:18
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1beta1
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// roundTrip marshals and unmarshals an object like the api server does
+func roundTrip(t *testing.T, src interface{}, dst interface{}) {
+	body, err := json.Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(body, dst); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvertFromAndBack(t *testing.T) {
+	tests := []struct {
+		name string
+		spec v1alpha1.PatchSpec
+	}{
+		{
+			name: "yaml merge patch",
+			spec: v1alpha1.PatchSpec{
+				Patches: []v1alpha1.PatchSpecPatch{{
+					Id:     "replicas",
+					Type:   v1alpha1.MergePatchType,
+					Target: v1alpha1.Target{Group: "apps", Version: "v1", Kind: "Deployment", Name: "web"},
+					Patch:  "# scale up\nspec:\n  replicas: 3\n",
+				}},
+			},
+		},
+		{
+			name: "json patch",
+			spec: v1alpha1.PatchSpec{
+				Patches: []v1alpha1.PatchSpecPatch{{
+					Type:   v1alpha1.JsonPatchType,
+					Target: v1alpha1.Target{ApiVersion: "v1", Kind: "ConfigMap", Name: "settings"},
+					Patch:  "- op: add\n  path: /data/key\n  value: value\n",
+				}},
+			},
+		},
+		{
+			name: "template and script",
+			spec: v1alpha1.PatchSpec{
+				Patches: []v1alpha1.PatchSpecPatch{
+					{
+						Target: v1alpha1.Target{ApiVersion: "v1", Kind: "ConfigMap", Name: "settings"},
+						Patch:  "data:\n  host: {{ .host }}\n",
+						Vars: []v1alpha1.PatchSpecPatchVar{{
+							Name:     "host",
+							Target:   v1alpha1.Target{Version: "v1", Kind: "Service", Name: "web"},
+							JsonPath: "{.spec.clusterIP}",
+						}},
+					},
+					{
+						Type:           v1alpha1.ScriptPatchType,
+						Target:         v1alpha1.Target{ApiVersion: "v1", Kind: "Namespace", Name: "default"},
+						Patch:          "kubectl get ns default\n",
+						WaitForTimeout: 5,
+					},
+				},
+			},
+		},
+		{
+			name: "durations",
+			spec: v1alpha1.PatchSpec{
+				ReapplyInterval: "5m",
+				RetryPolicy: &v1alpha1.PatchSpecRetryPolicy{
+					MaxAttempts: 3,
+					BackoffBase: "10s",
+					BackoffMax:  "1h",
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hub := &v1alpha1.Patch{
+				ObjectMeta: metav1.ObjectMeta{Name: "patch", Namespace: "default"},
+				Spec:       test.spec,
+			}
+			spoke := &Patch{}
+			if err := spoke.ConvertFrom(hub.DeepCopy()); err != nil {
+				t.Fatal(err)
+			}
+			written := &Patch{}
+			roundTrip(t, spoke, written)
+			result := &v1alpha1.Patch{}
+			if err := written.ConvertTo(result); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Spec, hub.Spec) {
+				t.Fatalf("spec changed after round trip\nexpected %+v\ngot      %+v", hub.Spec, result.Spec)
+			}
+			if result.Annotations != nil {
+				t.Fatalf("expected no annotations, got %v", result.Annotations)
+			}
+		})
+	}
+}
+
+func TestConvertFrom(t *testing.T) {
+	hub := &v1alpha1.Patch{
+		Spec: v1alpha1.PatchSpec{
+			Patches: []v1alpha1.PatchSpecPatch{
+				{
+					Target:         v1alpha1.Target{Group: "apps", Version: "v1", Kind: "Deployment", Name: "web"},
+					Patch:          "spec:\n  replicas: 3\n",
+					WaitForTimeout: 2,
+				},
+				{
+					Target: v1alpha1.Target{ApiVersion: "v1", Kind: "ConfigMap", Name: "settings"},
+					Patch:  "not structured",
+				},
+			},
+			ReapplyInterval: "90s",
+		},
+	}
+	spoke := &Patch{}
+	if err := spoke.ConvertFrom(hub); err != nil {
+		t.Fatal(err)
+	}
+	if apiVersion := spoke.Spec.Patches[0].Target.ApiVersion; apiVersion != "apps/v1" {
+		t.Fatalf("expected api version apps/v1, got %s", apiVersion)
+	}
+	if patch := string(spoke.Spec.Patches[0].Patch.Raw); patch != `{"spec":{"replicas":3}}` {
+		t.Fatalf("expected structured patch, got %s", patch)
+	}
+	if timeout := spoke.Spec.Patches[0].WaitForTimeout.Duration; timeout != 2*time.Second {
+		t.Fatalf("expected wait for timeout of 2s, got %s", timeout)
+	}
+	if template := spoke.Spec.Patches[1].Template; template != "not structured" {
+		t.Fatalf("expected template, got %q", template)
+	}
+	if interval := spoke.Spec.ReapplyInterval.Duration; interval != 90*time.Second {
+		t.Fatalf("expected reapply interval of 90s, got %s", interval)
+	}
+	if _, ok := spoke.Annotations[OriginalSpecAnnotation]; !ok {
+		t.Fatalf("expected annotation %s", OriginalSpecAnnotation)
+	}
+	if hub.Annotations != nil {
+		t.Fatalf("expected the hub annotations to be unchanged, got %v", hub.Annotations)
+	}
+}
+
+func TestConvertFromInvalidDuration(t *testing.T) {
+	hub := &v1alpha1.ClusterPatch{
+		Spec: v1alpha1.PatchSpec{ReapplyInterval: "often"},
+	}
+	if err := (&ClusterPatch{}).ConvertFrom(hub); err == nil {
+		t.Fatal("expected invalid reapply interval to fail")
+	}
+}
+
+func TestConvertToChanged(t *testing.T) {
+	hub := &v1alpha1.Patch{
+		Spec: v1alpha1.PatchSpec{
+			Patches: []v1alpha1.PatchSpecPatch{
+				{
+					Target: v1alpha1.Target{Group: "apps", Version: "v1", Kind: "Deployment", Name: "web"},
+					Patch:  "spec:\n  replicas: 3\n",
+				},
+				{
+					Target: v1alpha1.Target{Group: "apps", Version: "v1", Kind: "Deployment", Name: "api"},
+					Patch:  "spec:\n  replicas: 1\n",
+				},
+			},
+			ReapplyInterval: "5m",
+		},
+	}
+	spoke := &Patch{}
+	if err := spoke.ConvertFrom(hub.DeepCopy()); err != nil {
+		t.Fatal(err)
+	}
+	spoke.Spec.Patches[1].Patch = &runtime.RawExtension{Raw: []byte(`{"spec":{"replicas":2}}`)}
+	spoke.Spec.ReapplyInterval = &metav1.Duration{Duration: 10 * time.Minute}
+	result := &v1alpha1.Patch{}
+	if err := spoke.ConvertTo(result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Spec.Patches[0], hub.Spec.Patches[0]) {
+		t.Fatalf("expected unchanged patch to be restored, got %+v", result.Spec.Patches[0])
+	}
+	changed := result.Spec.Patches[1]
+	if changed.Patch != `{"spec":{"replicas":2}}` {
+		t.Fatalf("expected changed patch, got %s", changed.Patch)
+	}
+	if changed.Target.ApiVersion != "apps/v1" || changed.Target.Group != "" || changed.Target.Version != "" {
+		t.Fatalf("expected changed target to use api version, got %+v", changed.Target)
+	}
+	if result.Spec.ReapplyInterval != "10m0s" {
+		t.Fatalf("expected reapply interval of 10m0s, got %s", result.Spec.ReapplyInterval)
+	}
+}
+
+func TestConvertToAndBack(t *testing.T) {
+	spoke := &ClusterPatch{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "patch",
+			Annotations: map[string]string{"owner": "team"},
+		},
+		Spec: PatchSpec{
+			Patches: []PatchSpecPatch{{
+				Id:             "replicas",
+				Type:           StrategicPatchType,
+				Target:         Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web", Namespace: "default"},
+				Patch:          &runtime.RawExtension{Raw: []byte(`{"spec":{"replicas":3}}`)},
+				WaitForTimeout: &metav1.Duration{Duration: 1500 * time.Millisecond},
+			}},
+			RetryPolicy: &PatchSpecRetryPolicy{
+				BackoffBase: &metav1.Duration{Duration: time.Second},
+			},
+		},
+	}
+	hub := &v1alpha1.ClusterPatch{}
+	if err := spoke.ConvertTo(hub); err != nil {
+		t.Fatal(err)
+	}
+	if timeout := hub.Spec.Patches[0].WaitForTimeout; timeout != 2 {
+		t.Fatalf("expected wait for timeout to be rounded up to 2, got %d", timeout)
+	}
+	stored := &v1alpha1.ClusterPatch{}
+	roundTrip(t, hub, stored)
+	result := &ClusterPatch{}
+	if err := result.ConvertFrom(stored); err != nil {
+		t.Fatal(err)
+	}
+	delete(result.Annotations, OriginalSpecAnnotation)
+	if !reflect.DeepEqual(result.Annotations, spoke.Annotations) {
+		t.Fatalf("expected annotations %v, got %v", spoke.Annotations, result.Annotations)
+	}
+	spoke.Spec.Patches[0].WaitForTimeout = &metav1.Duration{Duration: 2 * time.Second}
+	if !reflect.DeepEqual(result.Spec, spoke.Spec) {
+		t.Fatalf("spec changed after round trip\nexpected %+v\ngot      %+v", spoke.Spec, result.Spec)
+	}
+}
+
+func TestConvertFromAnnotation(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     v1alpha1.PatchSpec
+		original *originalSpec
+	}{
+		{
+			name: "json patch",
+			spec: v1alpha1.PatchSpec{
+				Patches: []v1alpha1.PatchSpecPatch{{
+					Target: v1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+					Patch:  `{"spec":{"replicas":3}}`,
+				}},
+				ReapplyInterval: "5m0s",
+			},
+		},
+		{
+			name: "yaml patch",
+			spec: v1alpha1.PatchSpec{
+				Patches: []v1alpha1.PatchSpecPatch{
+					{
+						Target: v1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+						Patch:  `{"spec":{"replicas":3}}`,
+					},
+					{
+						Target: v1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "api"},
+						Patch:  "# scale down\nspec:\n  replicas: 1\n",
+					},
+				},
+			},
+			original: &originalSpec{
+				Patches: map[string]v1alpha1.PatchSpecPatch{
+					"1": {
+						Target: v1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "api"},
+						Patch:  "# scale down\nspec:\n  replicas: 1\n",
+					},
+				},
+			},
+		},
+		{
+			name: "group and version target",
+			spec: v1alpha1.PatchSpec{
+				Patches: []v1alpha1.PatchSpecPatch{{
+					Target: v1alpha1.Target{Group: "apps", Version: "v1", Kind: "Deployment", Name: "web"},
+					Patch:  `{"spec":{"replicas":3}}`,
+				}},
+			},
+			original: &originalSpec{
+				Patches: map[string]v1alpha1.PatchSpecPatch{
+					"0": {
+						Target: v1alpha1.Target{Group: "apps", Version: "v1", Kind: "Deployment", Name: "web"},
+						Patch:  `{"spec":{"replicas":3}}`,
+					},
+				},
+			},
+		},
+		{
+			name: "durations",
+			spec: v1alpha1.PatchSpec{
+				ReapplyInterval: "5m",
+				RetryPolicy: &v1alpha1.PatchSpecRetryPolicy{
+					BackoffBase: "10s",
+					BackoffMax:  "60m",
+				},
+			},
+			original: &originalSpec{
+				ReapplyInterval: "5m",
+				BackoffMax:      "60m",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hub := &v1alpha1.Patch{Spec: test.spec}
+			spoke := &Patch{}
+			if err := spoke.ConvertFrom(hub); err != nil {
+				t.Fatal(err)
+			}
+			body, ok := spoke.Annotations[OriginalSpecAnnotation]
+			if test.original == nil {
+				if ok {
+					t.Fatalf("expected no annotation, got %s", body)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("expected annotation %s", OriginalSpecAnnotation)
+			}
+			original := &originalSpec{}
+			if err := json.Unmarshal([]byte(body), original); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(original, test.original) {
+				t.Fatalf("expected annotation %+v, got %+v", test.original, original)
+			}
+		})
+	}
+}
+
+func TestConvertToAmbiguousPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch PatchSpecPatch
+		fails bool
+	}{
+		{
+			name:  "patch",
+			patch: PatchSpecPatch{Patch: &runtime.RawExtension{Raw: []byte(`{"spec":{"replicas":3}}`)}},
+		},
+		{
+			name:  "script",
+			patch: PatchSpecPatch{Type: ScriptPatchType, Script: "kubectl get ns\n"},
+		},
+		{
+			name: "patch and template",
+			patch: PatchSpecPatch{
+				Patch:    &runtime.RawExtension{Raw: []byte(`{"spec":{"replicas":3}}`)},
+				Template: "spec:\n  replicas: {{ .replicas }}\n",
+			},
+			fails: true,
+		},
+		{
+			name: "template and script",
+			patch: PatchSpecPatch{
+				Id:       "scale",
+				Template: "spec:\n  replicas: {{ .replicas }}\n",
+				Script:   "kubectl get ns\n",
+			},
+			fails: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spoke := &Patch{Spec: PatchSpec{Patches: []PatchSpecPatch{test.patch}}}
+			err := spoke.ConvertTo(&v1alpha1.Patch{})
+			if test.fails && err == nil {
+				t.Fatal("expected conversion to fail")
+			}
+			if !test.fails && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
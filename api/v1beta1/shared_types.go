@@ -0,0 +1,75 @@
+/**
+ * File: /shared_types.go
+ * Project: v1beta1
+ * File Created: 17-10-2026 18:04:11
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1beta1
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+// Target locates a resource
+type Target struct {
+	// api version of the resource, such as apps/v1
+	ApiVersion string `json:"apiVersion"`
+
+	Kind string `json:"kind"`
+
+	// name of the resource. glob patterns like my-* match multiple resources
+	Name string `json:"name,omitempty"`
+
+	Namespace string `json:"namespace,omitempty"`
+
+	// select the resources by label
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+
+	// select the namespaces of the resources by label. only used when no
+	// namespace is set
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+type NamespacedName struct {
+	// name
+	Name string `json:"name"`
+
+	// namespace
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type KeyRef struct {
+	// name
+	Name string `json:"name"`
+
+	// key
+	Key string `json:"key"`
+
+	// namespace, only allowed to differ from the namespace of the patch when
+	// cross namespace references are enabled
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type Phase string
+
+const (
+	FailedPhase    Phase = "Failed"
+	PendingPhase   Phase = "Pending"
+	ReadyPhase     Phase = "Ready"
+	SucceededPhase Phase = "Succeeded"
+	UnknownPhase   Phase = "Unknown"
+	WaitingPhase   Phase = "Waiting"
+)
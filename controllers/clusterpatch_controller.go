@@ -0,0 +1,188 @@
+/**
+ * File: /clusterpatch_controller.go
+ * Project: controllers
+ * File Created: 17-10-2026 16:21:08
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	"gitlab.com/bitspur/rock8s/patch-operator/util"
+)
+
+// ClusterPatchReconciler reconciles a ClusterPatch object
+type ClusterPatchReconciler struct {
+	Scheme      *runtime.Scheme
+	Recorder    record.EventRecorder
+	KubectlUtil *util.KubectlUtil
+	client.Client
+	clientset     kubernetes.Interface
+	targetWatcher *targetWatcher
+}
+
+//+kubebuilder:rbac:groups=patch.rock8s.com,resources=clusterpatches,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=patch.rock8s.com,resources=clusterpatches/finalizers,verbs=update
+//+kubebuilder:rbac:groups=patch.rock8s.com,resources=clusterpatches/status,verbs=get;update;patch
+
+// Reconcile reconciles a cluster patch with the same state machine as a patch.
+// the cluster patch is executed from the operator namespace
+func (r *ClusterPatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx, "clusterpatch", req.NamespacedName)
+	log.Log.Info("RECONCILING CLUSTER PATCH")
+	patchUtil := util.NewClusterPatchUtil(&r.Client, r.clientset, r.KubectlUtil, &ctx, &req, r.Scheme, log.Log,
+		req.NamespacedName.Name, r.Recorder,
+	)
+	patch, err := patchUtil.Get()
+	if err != nil {
+		if errors.IsNotFound(err) {
+			patchUtil.DeleteMetrics()
+			r.targetWatcher.forget(req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	return reconcilePatch(patchUtil, patch, r.targetWatcher, req.NamespacedName)
+}
+
+func (r *ClusterPatchReconciler) mapTargetToClusterPatches(obj client.Object) []reconcile.Request {
+	groupKind := obj.GetObjectKind().GroupVersionKind().GroupKind()
+	requests := []reconcile.Request{}
+	seen := map[string]bool{}
+	for _, name := range []string{obj.GetName(), util.WildcardTargetName} {
+		clusterPatchList := &patchv1alpha1.ClusterPatchList{}
+		if err := r.List(context.Background(), clusterPatchList, client.MatchingFields{
+			util.TargetIndexField: util.TargetIndexKey(groupKind, name),
+		}); err != nil {
+			log.Log.Error(err, "unable to list cluster patches targeting resource")
+			return []reconcile.Request{}
+		}
+		for i := range clusterPatchList.Items {
+			patch := util.ClusterPatchToPatch(&clusterPatchList.Items[i])
+			if seen[patch.GetName()] || !util.PatchTargetsObject(patch, obj) {
+				continue
+			}
+			seen[patch.GetName()] = true
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Name: patch.GetName(),
+			}})
+		}
+	}
+	return requests
+}
+
+// mapConfigMapToClusterPatches enqueues the cluster patches that read their
+// patches from a configmap
+func (r *ClusterPatchReconciler) mapConfigMapToClusterPatches(obj client.Object) []reconcile.Request {
+	return r.listClusterPatches(util.PatchFromIndexField, util.PatchFromIndexKey(util.ConfigMapKind, obj.GetName(), obj.GetNamespace()))
+}
+
+// mapSecretToClusterPatches enqueues the cluster patches that read their
+// patches from a secret
+func (r *ClusterPatchReconciler) mapSecretToClusterPatches(obj client.Object) []reconcile.Request {
+	return r.listClusterPatches(util.PatchFromIndexField, util.PatchFromIndexKey(util.SecretKind, obj.GetName(), obj.GetNamespace()))
+}
+
+// mapClusterPatchToDependents enqueues the cluster patches that depend on a
+// cluster patch
+func (r *ClusterPatchReconciler) mapClusterPatchToDependents(obj client.Object) []reconcile.Request {
+	return r.listClusterPatches(util.DependsOnIndexField, types.NamespacedName{
+		Name:      obj.GetName(),
+		Namespace: util.GetOperatorNamespace(),
+	}.String())
+}
+
+func (r *ClusterPatchReconciler) listClusterPatches(field string, value string) []reconcile.Request {
+	clusterPatchList := &patchv1alpha1.ClusterPatchList{}
+	if err := r.List(context.Background(), clusterPatchList, client.MatchingFields{
+		field: value,
+	}); err != nil {
+		log.Log.Error(err, "unable to list cluster patches", "field", field)
+		return []reconcile.Request{}
+	}
+	requests := []reconcile.Request{}
+	for _, clusterPatch := range clusterPatchList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Name: clusterPatch.GetName(),
+		}})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterPatchReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	r.clientset = clientset
+	maxConcurrentReconciles := 3
+	if value := os.Getenv("MAX_CONCURRENT_RECONCILES"); value != "" {
+		if val, err := strconv.Atoi(value); err == nil {
+			maxConcurrentReconciles = val
+		}
+	}
+	for field, indexer := range map[string]client.IndexerFunc{
+		util.TargetIndexField:    util.IndexPatchTargets,
+		util.PatchFromIndexField: util.IndexPatchFrom,
+		util.DependsOnIndexField: util.IndexPatchDependencies,
+	} {
+		if err := mgr.GetFieldIndexer().IndexField(
+			context.Background(),
+			&patchv1alpha1.ClusterPatch{},
+			field,
+			indexer,
+		); err != nil {
+			return err
+		}
+	}
+	c, err := ctrl.NewControllerManagedBy(mgr).
+		For(&patchv1alpha1.ClusterPatch{}).
+		Watches(&source.Kind{Type: &patchv1alpha1.ClusterPatch{}}, handler.EnqueueRequestsFromMapFunc(r.mapClusterPatchToDependents)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.mapConfigMapToClusterPatches), builder.OnlyMetadata).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.mapSecretToClusterPatches), builder.OnlyMetadata).
+		Owns(&batchv1.Job{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: maxConcurrentReconciles}).
+		WithEventFilter(filterPatchPredicate()).
+		Build(r)
+	if err != nil {
+		return err
+	}
+	r.targetWatcher = newTargetWatcher(c, r.KubectlUtil, r.mapTargetToClusterPatches)
+	return mgr.Add(r.targetWatcher)
+}
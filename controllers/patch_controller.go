@@ -26,14 +26,23 @@ import (
 	"os"
 	"strconv"
 
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
 	"gitlab.com/bitspur/rock8s/patch-operator/util"
@@ -41,11 +50,18 @@ import (
 
 // PatchReconciler reconciles a Patch object
 type PatchReconciler struct {
-	Scheme *runtime.Scheme
+	Scheme      *runtime.Scheme
+	Recorder    record.EventRecorder
+	KubectlUtil *util.KubectlUtil
 	client.Client
+	clientset     kubernetes.Interface
+	targetWatcher *targetWatcher
 }
 
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=impersonate
 //+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+//+kubebuilder:rbac:groups="",resources=pods/log,verbs=get
 //+kubebuilder:rbac:groups=patch.rock8s.com,resources=patches,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=patch.rock8s.com,resources=patches/finalizers,verbs=update
 //+kubebuilder:rbac:groups=patch.rock8s.com,resources=patches/status,verbs=get;update;patch
@@ -62,20 +78,31 @@ type PatchReconciler struct {
 func (r *PatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = log.FromContext(ctx, "patch", req.NamespacedName)
 	log.Log.Info("RECONCILING PATCH")
-	patchUtil := util.NewPatchUtil(&r.Client, &ctx, &req, r.Scheme, log.Log,
+	patchUtil := util.NewPatchUtil(&r.Client, r.clientset, r.KubectlUtil, &ctx, &req, r.Scheme, log.Log,
 		&patchv1alpha1.NamespacedName{
 			Name:      req.NamespacedName.Name,
 			Namespace: req.NamespacedName.Namespace,
-		}, util.GlobalPatchMutex,
+		}, r.Recorder,
 	)
 	patch, err := patchUtil.Get()
 	if err != nil {
 		if errors.IsNotFound(err) {
+			patchUtil.DeleteMetrics()
+			r.targetWatcher.forget(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
 	}
+	return reconcilePatch(patchUtil, patch, r.targetWatcher, req.NamespacedName)
+}
 
+// reconcilePatch runs the state machine shared by patches and cluster patches
+func reconcilePatch(
+	patchUtil *util.PatchUtil,
+	patch *patchv1alpha1.Patch,
+	targetWatcher *targetWatcher,
+	key types.NamespacedName,
+) (ctrl.Result, error) {
 	if patchUtil.FinalizeProbe(patch) {
 		return patchUtil.Finalize(patch)
 	}
@@ -84,6 +111,12 @@ func (r *PatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		return patchUtil.InitializeFinalizer(patch)
 	}
 
+	if patch.Spec.ReconcileMode == patchv1alpha1.ContinuousReconcileMode || util.HasMultiTargets(patch) {
+		targetWatcher.watch(key, patch)
+	} else {
+		targetWatcher.unwatch(key)
+	}
+
 	pause, err := patchUtil.PauseProbe(patch)
 	if err != nil {
 		return patchUtil.Error((err))
@@ -92,7 +125,18 @@ func (r *PatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		return patchUtil.Pause(patch)
 	}
 
+	if patchUtil.RetryProbe(patch) {
+		return patchUtil.Retry(patch)
+	}
+
 	if patchUtil.PatchingProbe(patch) {
+		waiting, err := patchUtil.DependencyProbe(patch)
+		if err != nil {
+			return patchUtil.Error(err)
+		}
+		if waiting != "" {
+			return patchUtil.Depend(patch, waiting)
+		}
 		return patchUtil.Patching(patch)
 	}
 
@@ -108,7 +152,120 @@ func (r *PatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		return patchUtil.Recalibrate(patch)
 	}
 
-	return ctrl.Result{}, nil
+	rematch, err := patchUtil.RematchProbe(patch)
+	if err != nil {
+		return patchUtil.Error(err)
+	}
+	if rematch {
+		return patchUtil.Rematch(patch)
+	}
+
+	if patch.Spec.ReconcileMode == patchv1alpha1.ContinuousReconcileMode && targetWatcher.driftCheckDue(key) {
+		drift, err := patchUtil.DriftProbe(patch)
+		if err != nil {
+			targetWatcher.targetsChanged([]reconcile.Request{{NamespacedName: key}})
+			return patchUtil.Error(err)
+		}
+		if drift {
+			return patchUtil.Drift(patch)
+		}
+	}
+
+	schedule, err := patchUtil.ScheduleProbe(patch)
+	if err != nil {
+		return patchUtil.Error(err)
+	}
+	if schedule {
+		return patchUtil.Schedule(patch)
+	}
+
+	result, err := patchUtil.Scheduled(patch)
+	if err == nil && patch.Spec.ReconcileMode == patchv1alpha1.ContinuousReconcileMode &&
+		(result.RequeueAfter == 0 || result.RequeueAfter > driftResyncInterval) {
+		result.RequeueAfter = driftResyncInterval
+	}
+	return result, err
+}
+
+func (r *PatchReconciler) mapTargetToPatches(obj client.Object) []reconcile.Request {
+	groupKind := obj.GetObjectKind().GroupVersionKind().GroupKind()
+	requests := []reconcile.Request{}
+	seen := map[types.NamespacedName]bool{}
+	for _, name := range []string{obj.GetName(), util.WildcardTargetName} {
+		patchList := &patchv1alpha1.PatchList{}
+		if err := r.List(context.Background(), patchList, client.MatchingFields{
+			util.TargetIndexField: util.TargetIndexKey(groupKind, name),
+		}); err != nil {
+			log.Log.Error(err, "unable to list patches targeting resource")
+			return []reconcile.Request{}
+		}
+		for i := range patchList.Items {
+			patch := &patchList.Items[i]
+			namespacedName := types.NamespacedName{
+				Name:      patch.GetName(),
+				Namespace: patch.GetNamespace(),
+			}
+			if seen[namespacedName] || !util.PatchTargetsObject(patch, obj) {
+				continue
+			}
+			seen[namespacedName] = true
+			requests = append(requests, reconcile.Request{NamespacedName: namespacedName})
+		}
+	}
+	return requests
+}
+
+// mapConfigMapToPatches enqueues the patches that read their patches from a
+// configmap. configmaps are only watched by their metadata
+func (r *PatchReconciler) mapConfigMapToPatches(obj client.Object) []reconcile.Request {
+	return r.mapPatchFromToPatches(util.ConfigMapKind, obj)
+}
+
+// mapSecretToPatches enqueues the patches that read their patches from a
+// secret. secrets are only watched by their metadata, so the operator never
+// caches their data
+func (r *PatchReconciler) mapSecretToPatches(obj client.Object) []reconcile.Request {
+	return r.mapPatchFromToPatches(util.SecretKind, obj)
+}
+
+func (r *PatchReconciler) mapPatchFromToPatches(kind string, obj client.Object) []reconcile.Request {
+	patchList := &patchv1alpha1.PatchList{}
+	if err := r.List(context.Background(), patchList, client.MatchingFields{
+		util.PatchFromIndexField: util.PatchFromIndexKey(kind, obj.GetName(), obj.GetNamespace()),
+	}); err != nil {
+		log.Log.Error(err, "unable to list patches reading from resource")
+		return []reconcile.Request{}
+	}
+	requests := []reconcile.Request{}
+	for _, patch := range patchList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      patch.GetName(),
+			Namespace: patch.GetNamespace(),
+		}})
+	}
+	return requests
+}
+
+// mapPatchToDependents enqueues the patches that depend on a patch
+func (r *PatchReconciler) mapPatchToDependents(obj client.Object) []reconcile.Request {
+	patchList := &patchv1alpha1.PatchList{}
+	if err := r.List(context.Background(), patchList, client.MatchingFields{
+		util.DependsOnIndexField: types.NamespacedName{
+			Name:      obj.GetName(),
+			Namespace: obj.GetNamespace(),
+		}.String(),
+	}); err != nil {
+		log.Log.Error(err, "unable to list patches depending on patch")
+		return []reconcile.Request{}
+	}
+	requests := []reconcile.Request{}
+	for _, patch := range patchList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      patch.GetName(),
+			Namespace: patch.GetNamespace(),
+		}})
+	}
+	return requests
 }
 
 func filterPatchPredicate() predicate.Predicate {
@@ -125,15 +282,53 @@ func filterPatchPredicate() predicate.Predicate {
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *PatchReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	r.clientset = clientset
 	maxConcurrentReconciles := 3
 	if value := os.Getenv("MAX_CONCURRENT_RECONCILES"); value != "" {
 		if val, err := strconv.Atoi(value); err == nil {
 			maxConcurrentReconciles = val
 		}
 	}
-	return ctrl.NewControllerManagedBy(mgr).
+	if err := mgr.GetFieldIndexer().IndexField(
+		context.Background(),
+		&patchv1alpha1.Patch{},
+		util.TargetIndexField,
+		util.IndexPatchTargets,
+	); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(
+		context.Background(),
+		&patchv1alpha1.Patch{},
+		util.PatchFromIndexField,
+		util.IndexPatchFrom,
+	); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(
+		context.Background(),
+		&patchv1alpha1.Patch{},
+		util.DependsOnIndexField,
+		util.IndexPatchDependencies,
+	); err != nil {
+		return err
+	}
+	c, err := ctrl.NewControllerManagedBy(mgr).
 		For(&patchv1alpha1.Patch{}).
+		Watches(&source.Kind{Type: &patchv1alpha1.Patch{}}, handler.EnqueueRequestsFromMapFunc(r.mapPatchToDependents)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.mapConfigMapToPatches), builder.OnlyMetadata).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.mapSecretToPatches), builder.OnlyMetadata).
+		Owns(&batchv1.Job{}).
 		WithOptions(controller.Options{MaxConcurrentReconciles: maxConcurrentReconciles}).
 		WithEventFilter(filterPatchPredicate()).
-		Complete(r)
+		Build(r)
+	if err != nil {
+		return err
+	}
+	r.targetWatcher = newTargetWatcher(c, r.KubectlUtil, r.mapTargetToPatches)
+	return mgr.Add(r.targetWatcher)
 }
@@ -0,0 +1,265 @@
+/**
+ * File: /target_watcher.go
+ * Project: controllers
+ * File Created: 17-10-2026 06:45:20
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	"gitlab.com/bitspur/rock8s/patch-operator/util"
+)
+
+// targetWatcher watches the metadata of the resources targeted by patches. the
+// resources are watched as the service account of the patch, so the operator
+// does not need access to them
+type targetWatcher struct {
+	changed     map[types.NamespacedName]bool
+	controller  controller.Controller
+	driftChecks map[types.NamespacedName]time.Time
+	informers   map[targetInformerKey]*targetInformer
+	kubectlUtil *util.KubectlUtil
+	mapFunc     handler.MapFunc
+	mutex       sync.Mutex
+	stopped     bool
+}
+
+// driftResyncInterval is the interval at which continuous patches are checked
+// for drift when none of their targets changed
+const driftResyncInterval = time.Duration(time.Minute * 10)
+
+// targetInformerKey identifies an informer by the service account it
+// impersonates and the resource and namespace it watches
+type targetInformerKey struct {
+	namespace      string
+	resource       schema.GroupVersionResource
+	serviceAccount string
+}
+
+// targetInformer is a running informer and the patches that use it. the
+// informer is stopped when the last patch stops using it
+type targetInformer struct {
+	patches map[types.NamespacedName]bool
+	stop    chan struct{}
+}
+
+func newTargetWatcher(
+	c controller.Controller,
+	kubectlUtil *util.KubectlUtil,
+	mapFunc handler.MapFunc,
+) *targetWatcher {
+	return &targetWatcher{
+		changed:     map[types.NamespacedName]bool{},
+		controller:  c,
+		driftChecks: map[types.NamespacedName]time.Time{},
+		informers:   map[targetInformerKey]*targetInformer{},
+		kubectlUtil: kubectlUtil,
+		mapFunc:     mapFunc,
+	}
+}
+
+// Start stops the informers when the manager stops
+func (w *targetWatcher) Start(ctx context.Context) error {
+	<-ctx.Done()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.stopped = true
+	for key, informer := range w.informers {
+		close(informer.stop)
+		delete(w.informers, key)
+	}
+	return nil
+}
+
+// watch starts watching the resources targeted by a continuous patch or by a
+// patch with targets that match multiple resources, and stops the informers
+// the patch no longer uses
+func (w *targetWatcher) watch(key types.NamespacedName, patch *patchv1alpha1.Patch) {
+	serviceAccountName := util.Default(patch.Spec.ServiceAccountName, util.GetDefaultServiceAccountName())
+	kubectlUtil := w.kubectlUtil.ForServiceAccount(patch.GetNamespace(), serviceAccountName)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopped {
+		return
+	}
+	used := map[targetInformerKey]bool{}
+	for i, patchItem := range patch.Spec.Patches {
+		resource, err := util.TargetToResource(fmt.Sprint(i), patch, &patchItem.Target)
+		if err != nil {
+			continue
+		}
+		gvk := resource.GroupVersionKind()
+		mapping, err := kubectlUtil.RESTMapping(gvk)
+		if err != nil {
+			log.Log.Info("unable to watch patch target", "kind", gvk.String(), "error", err.Error())
+			continue
+		}
+		namespace := resource.GetNamespace()
+		if mapping.Scope.Name() == meta.RESTScopeNameRoot ||
+			(patchItem.Target.Namespace == "" && patchItem.Target.NamespaceSelector != nil) {
+			namespace = ""
+		}
+		informerKey := targetInformerKey{
+			namespace:      namespace,
+			resource:       mapping.Resource,
+			serviceAccount: patch.GetNamespace() + "/" + serviceAccountName,
+		}
+		if informer, ok := w.informers[informerKey]; ok {
+			informer.patches[key] = true
+			used[informerKey] = true
+			continue
+		}
+		stop := make(chan struct{})
+		if err := w.startInformer(kubectlUtil, gvk, informerKey, stop); err != nil {
+			log.Log.Info(
+				"unable to watch patch target",
+				"kind", gvk.String(),
+				"namespace", namespace,
+				"serviceAccount", informerKey.serviceAccount,
+				"error", err.Error(),
+			)
+			continue
+		}
+		w.informers[informerKey] = &targetInformer{
+			patches: map[types.NamespacedName]bool{key: true},
+			stop:    stop,
+		}
+		used[informerKey] = true
+	}
+	w.release(key, used)
+}
+
+// unwatch stops the informers of a patch that no longer needs its targets
+// watched
+func (w *targetWatcher) unwatch(key types.NamespacedName) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.release(key, nil)
+}
+
+// release removes the patch from the informers it no longer uses and stops
+// the informers no patch uses. the mutex must be held
+func (w *targetWatcher) release(key types.NamespacedName, used map[targetInformerKey]bool) {
+	for informerKey, informer := range w.informers {
+		if used[informerKey] || !informer.patches[key] {
+			continue
+		}
+		delete(informer.patches, key)
+		if len(informer.patches) == 0 {
+			close(informer.stop)
+			delete(w.informers, informerKey)
+		}
+	}
+}
+
+// startInformer starts a metadata informer impersonating the service account.
+// the service account must be allowed to list and watch the resource
+func (w *targetWatcher) startInformer(
+	kubectlUtil *util.KubectlUtil,
+	gvk schema.GroupVersionKind,
+	key targetInformerKey,
+	stop chan struct{},
+) error {
+	metadataClient, err := kubectlUtil.MetadataClient()
+	if err != nil {
+		return err
+	}
+	if _, err := metadataClient.Resource(key.resource).Namespace(key.namespace).List(
+		context.Background(),
+		metav1.ListOptions{Limit: 1},
+	); err != nil {
+		return err
+	}
+	informer := metadatainformer.NewFilteredMetadataInformer(
+		metadataClient,
+		key.resource,
+		key.namespace,
+		0,
+		cache.Indexers{},
+		nil,
+	).Informer()
+	if err := w.controller.Watch(
+		&source.Informer{Informer: informer},
+		handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+			// the metadata of the resource does not include its kind
+			obj = obj.DeepCopyObject().(client.Object)
+			obj.GetObjectKind().SetGroupVersionKind(gvk)
+			requests := w.mapFunc(obj)
+			w.targetsChanged(requests)
+			return requests
+		}),
+	); err != nil {
+		return err
+	}
+	go informer.Run(stop)
+	return nil
+}
+
+// targetsChanged records that a target of the patches changed, so they are
+// checked for drift when they are reconciled
+func (w *targetWatcher) targetsChanged(requests []reconcile.Request) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, request := range requests {
+		w.changed[request.NamespacedName] = true
+	}
+}
+
+// driftCheckDue returns true if a target of the patch changed or the patch
+// was not checked for drift within the resync interval. the dry runs of the
+// drift check are skipped for reconciles triggered by anything else, such as
+// the status writes of the operator
+func (w *targetWatcher) driftCheckDue(key types.NamespacedName) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.changed[key] && time.Since(w.driftChecks[key]) < driftResyncInterval {
+		return false
+	}
+	delete(w.changed, key)
+	w.driftChecks[key] = time.Now()
+	return true
+}
+
+// forget removes the drift state of a patch that no longer exists and stops
+// the informers only it used
+func (w *targetWatcher) forget(key types.NamespacedName) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	delete(w.changed, key)
+	delete(w.driftChecks, key)
+	w.release(key, nil)
+}
@@ -0,0 +1,152 @@
+/**
+ * File: /target_watcher_test.go
+ * Project: controllers
+ * File Created: 17-10-2026 06:46:28
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestDriftCheckDue(t *testing.T) {
+	key := types.NamespacedName{Name: "web", Namespace: "default"}
+	tests := []struct {
+		name      string
+		lastCheck time.Duration
+		changed   bool
+		due       bool
+	}{
+		{name: "never checked", due: true},
+		{name: "checked recently", lastCheck: time.Minute},
+		{name: "target changed", lastCheck: time.Minute, changed: true, due: true},
+		{name: "resync interval passed", lastCheck: driftResyncInterval + time.Minute, due: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := newTargetWatcher(nil, nil, nil)
+			if test.lastCheck > 0 {
+				w.driftChecks[key] = time.Now().Add(-test.lastCheck)
+			}
+			if test.changed {
+				w.targetsChanged([]reconcile.Request{{NamespacedName: key}})
+			}
+			if due := w.driftCheckDue(key); due != test.due {
+				t.Fatalf("expected drift check due to be %t, got %t", test.due, due)
+			}
+			if w.driftCheckDue(key) {
+				t.Fatal("expected drift check not to be due right after a check")
+			}
+		})
+	}
+}
+
+func TestForgetStopsInformers(t *testing.T) {
+	web := types.NamespacedName{Name: "web", Namespace: "default"}
+	api := types.NamespacedName{Name: "api", Namespace: "default"}
+	deployments := targetInformerKey{
+		namespace:      "default",
+		resource:       schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		serviceAccount: "default/patch-operator",
+	}
+	services := targetInformerKey{
+		namespace:      "default",
+		resource:       schema.GroupVersionResource{Version: "v1", Resource: "services"},
+		serviceAccount: "default/patch-operator",
+	}
+	w := newTargetWatcher(nil, nil, nil)
+	w.informers[deployments] = &targetInformer{
+		patches: map[types.NamespacedName]bool{web: true, api: true},
+		stop:    make(chan struct{}),
+	}
+	w.informers[services] = &targetInformer{
+		patches: map[types.NamespacedName]bool{web: true},
+		stop:    make(chan struct{}),
+	}
+	deploymentsStop := w.informers[deployments].stop
+	servicesStop := w.informers[services].stop
+	tests := []struct {
+		name    string
+		forget  types.NamespacedName
+		stopped map[targetInformerKey]bool
+	}{
+		{
+			name:    "informer still used by another patch",
+			forget:  web,
+			stopped: map[targetInformerKey]bool{services: true},
+		},
+		{
+			name:    "last patch using the informer",
+			forget:  api,
+			stopped: map[targetInformerKey]bool{deployments: true, services: true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w.forget(test.forget)
+			for key, stop := range map[targetInformerKey]chan struct{}{
+				deployments: deploymentsStop,
+				services:    servicesStop,
+			} {
+				if stopped := isClosed(stop); stopped != test.stopped[key] {
+					t.Fatalf("expected %s informer stopped to be %t, got %t", key.resource.Resource, test.stopped[key], stopped)
+				}
+				if _, ok := w.informers[key]; ok == test.stopped[key] {
+					t.Fatalf("expected %s informer tracked to be %t, got %t", key.resource.Resource, !test.stopped[key], ok)
+				}
+			}
+		})
+	}
+}
+
+func TestUnwatchStopsInformers(t *testing.T) {
+	key := types.NamespacedName{Name: "web", Namespace: "default"}
+	informerKey := targetInformerKey{
+		namespace:      "default",
+		resource:       schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		serviceAccount: "default/patch-operator",
+	}
+	stop := make(chan struct{})
+	w := newTargetWatcher(nil, nil, nil)
+	w.informers[informerKey] = &targetInformer{
+		patches: map[types.NamespacedName]bool{key: true},
+		stop:    stop,
+	}
+	w.unwatch(key)
+	if !isClosed(stop) {
+		t.Fatal("expected informer to be stopped")
+	}
+	if len(w.informers) > 0 {
+		t.Fatal("expected informer to be removed")
+	}
+}
+
+func isClosed(stop chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -22,8 +22,11 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -37,7 +40,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	patchv1beta1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1beta1"
 	"gitlab.com/bitspur/rock8s/patch-operator/controllers"
+	"gitlab.com/bitspur/rock8s/patch-operator/util"
+	"gitlab.com/bitspur/rock8s/patch-operator/webhooks"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -50,13 +56,24 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(patchv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(patchv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 //+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == util.RunnerCommand {
+		if err := util.RunRunner(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
@@ -86,13 +103,44 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := util.LookupOperatorImage(context.Background(), mgr.GetAPIReader()); err != nil {
+		setupLog.Error(err, "unable to look up operator image")
+	}
+
+	kubectlUtil, err := util.NewKubectlUtilForConfig(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create kubectl util")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.PatchReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("patch-controller"),
+		KubectlUtil: kubectlUtil,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Patch")
 		os.Exit(1)
 	}
+	if err = (&controllers.ClusterPatchReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("clusterpatch-controller"),
+		KubectlUtil: kubectlUtil,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterPatch")
+		os.Exit(1)
+	}
+	if err = util.RegisterPatchCollector(mgr.GetClient()); err != nil {
+		setupLog.Error(err, "unable to register metrics collector")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") == "true" {
+		if err = webhooks.SetupPatchWebhookWithManager(mgr, kubectlUtil); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Patch")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
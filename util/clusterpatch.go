@@ -0,0 +1,63 @@
+/**
+ * File: /clusterpatch.go
+ * Project: util
+ * File Created: 17-10-2026 16:09:41
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AsPatch returns the patch of a patch or cluster patch. cluster patches are
+// returned as a patch in the operator namespace, so they share the patch logic
+func AsPatch(obj client.Object) (*patchv1alpha1.Patch, bool) {
+	switch o := obj.(type) {
+	case *patchv1alpha1.Patch:
+		return o, true
+	case *patchv1alpha1.ClusterPatch:
+		return ClusterPatchToPatch(o), true
+	}
+	return nil, false
+}
+
+// ClusterPatchToPatch returns a cluster patch as a patch in the operator namespace
+func ClusterPatchToPatch(clusterPatch *patchv1alpha1.ClusterPatch) *patchv1alpha1.Patch {
+	patch := &patchv1alpha1.Patch{
+		TypeMeta: clusterPatch.TypeMeta,
+		Spec:     *clusterPatch.Spec.DeepCopy(),
+		Status:   *clusterPatch.Status.DeepCopy(),
+	}
+	clusterPatch.ObjectMeta.DeepCopyInto(&patch.ObjectMeta)
+	patch.SetNamespace(GetOperatorNamespace())
+	return patch
+}
+
+// PatchToClusterPatch returns a patch created by ClusterPatchToPatch as a cluster patch
+func PatchToClusterPatch(patch *patchv1alpha1.Patch) *patchv1alpha1.ClusterPatch {
+	clusterPatch := &patchv1alpha1.ClusterPatch{
+		TypeMeta: patch.TypeMeta,
+		Spec:     *patch.Spec.DeepCopy(),
+		Status:   *patch.Status.DeepCopy(),
+	}
+	patch.ObjectMeta.DeepCopyInto(&clusterPatch.ObjectMeta)
+	clusterPatch.SetNamespace("")
+	return clusterPatch
+}
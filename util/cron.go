@@ -0,0 +1,61 @@
+/**
+ * File: /cron.go
+ * Project: util
+ * File Created: 17-10-2026 14:48:02
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// CronSchedule is a standard five field cron schedule
+// (minute hour day-of-month month day-of-week) in a time zone. it is parsed
+// with the same parser as the schedules of cron jobs
+type CronSchedule struct {
+	schedule cron.Schedule
+}
+
+// ParseCronSchedule parses a cron schedule. the time zone defaults to UTC and
+// can also be set with a CRON_TZ= or TZ= prefix
+func ParseCronSchedule(schedule string, timeZone string) (*CronSchedule, error) {
+	schedule = strings.TrimSpace(schedule)
+	if !strings.HasPrefix(schedule, "CRON_TZ=") && !strings.HasPrefix(schedule, "TZ=") {
+		if _, err := time.LoadLocation(Default(timeZone, "UTC")); err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid time zone %s: %s", timeZone, err.Error()))
+		}
+		schedule = fmt.Sprintf("CRON_TZ=%s %s", Default(timeZone, "UTC"), schedule)
+	}
+	cronSchedule, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid schedule %s: %s", schedule, err.Error()))
+	}
+	return &CronSchedule{schedule: cronSchedule}, nil
+}
+
+// Next returns the first time after t that matches the schedule or the zero
+// time if the schedule never matches
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	return c.schedule.Next(t)
+}
@@ -0,0 +1,92 @@
+/**
+ * File: /cron_test.go
+ * Project: util
+ * File Created: 17-10-2026 06:30:26
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	utc := func(value string) time.Time {
+		result, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	tests := []struct {
+		name     string
+		schedule string
+		timeZone string
+		from     string
+		next     string
+		invalid  bool
+	}{
+		{name: "every minute", schedule: "* * * * *", from: "2026-10-17T10:15:30Z", next: "2026-10-17T10:16:00Z"},
+		{name: "fixed time", schedule: "30 2 * * *", from: "2026-10-17T10:15:00Z", next: "2026-10-18T02:30:00Z"},
+		{name: "range", schedule: "0 9-17 * * *", from: "2026-10-17T17:30:00Z", next: "2026-10-18T09:00:00Z"},
+		{name: "step", schedule: "*/15 * * * *", from: "2026-10-17T10:16:00Z", next: "2026-10-17T10:30:00Z"},
+		{name: "list", schedule: "0 0 1,15 * *", from: "2026-10-02T00:00:00Z", next: "2026-10-15T00:00:00Z"},
+		{name: "month name", schedule: "0 0 1 jan *", from: "2026-10-17T00:00:00Z", next: "2027-01-01T00:00:00Z"},
+		{name: "weekday name", schedule: "0 0 * * mon", from: "2026-10-17T00:00:00Z", next: "2026-10-19T00:00:00Z"},
+		{name: "day of month or week", schedule: "0 0 20 * sun", from: "2026-10-17T00:00:00Z", next: "2026-10-18T00:00:00Z"},
+		{name: "daily macro", schedule: "@daily", from: "2026-10-17T10:00:00Z", next: "2026-10-18T00:00:00Z"},
+		{name: "hourly macro", schedule: "@hourly", from: "2026-10-17T10:00:00Z", next: "2026-10-17T11:00:00Z"},
+		{name: "time zone", schedule: "0 2 * * *", timeZone: "America/Chicago", from: "2026-10-17T00:00:00Z", next: "2026-10-17T07:00:00Z"},
+		{name: "time zone prefix", schedule: "CRON_TZ=Asia/Tokyo 0 9 * * *", from: "2026-10-17T00:00:00Z", next: "2026-10-18T00:00:00Z"},
+		{name: "time zone prefix wins", schedule: "TZ=UTC 0 9 * * *", timeZone: "Asia/Tokyo", from: "2026-10-17T00:00:00Z", next: "2026-10-17T09:00:00Z"},
+		{name: "skipped by daylight saving", schedule: "30 2 * * *", timeZone: "America/New_York", from: "2027-03-14T05:00:00Z", next: "2027-03-15T06:30:00Z"},
+		{name: "never", schedule: "0 0 30 2 *", from: "2026-10-17T00:00:00Z", next: ""},
+		{name: "too few fields", schedule: "* * * *", invalid: true},
+		{name: "too many fields", schedule: "0 * * * * *", invalid: true},
+		{name: "out of range", schedule: "60 * * * *", invalid: true},
+		{name: "bad step", schedule: "*/0 * * * *", invalid: true},
+		{name: "unknown macro", schedule: "@sometimes", invalid: true},
+		{name: "unknown time zone", schedule: "* * * * *", timeZone: "Mars/Olympus", invalid: true},
+		{name: "empty", schedule: "", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseCronSchedule(test.schedule, test.timeZone)
+			if test.invalid {
+				if err == nil {
+					t.Fatalf("expected schedule %q to be invalid", test.schedule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := schedule.Next(utc(test.from))
+			if test.next == "" {
+				if !next.IsZero() {
+					t.Fatalf("expected no next time, got %s", next)
+				}
+				return
+			}
+			if !next.Equal(utc(test.next)) {
+				t.Fatalf("expected %s, got %s", test.next, next.UTC().Format(time.RFC3339))
+			}
+		})
+	}
+}
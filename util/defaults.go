@@ -0,0 +1,205 @@
+/**
+ * File: /defaults.go
+ * Project: util
+ * File Created: 17-10-2026 17:31:54
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultImage is the image of patch jobs when no image is configured
+const DefaultImage = "registry.gitlab.com/bitspur/rock8s/images/kube-commands:3.18.0"
+
+// DefaultRunnerImage is the image of the jobs that run patches with the runner
+// when no runner image is configured and the image of the operator pod is not
+// known, such as when the operator runs outside of the cluster
+const DefaultRunnerImage = "registry.gitlab.com/bitspur/rock8s/patch-operator"
+
+var operatorImage string
+
+// DefaultServiceAccountName is the service account of patches when no service
+// account is configured
+const DefaultServiceAccountName = "default"
+
+// DefaultLogsHistoryLimit is the number of configmaps with the logs of previous
+// jobs kept when the patch does not set a limit
+const DefaultLogsHistoryLimit int32 = 3
+
+// DefaultSuccessfulRunsHistoryLimit is the number of successful runs kept when
+// the patch does not set a limit
+const DefaultSuccessfulRunsHistoryLimit int32 = 3
+
+// DefaultFailedRunsHistoryLimit is the number of failed runs kept when the
+// patch does not set a limit
+const DefaultFailedRunsHistoryLimit int32 = 1
+
+// MaxRunsHistoryLimit is the most successful or failed runs kept in the status,
+// for patches created before the limits were validated
+const MaxRunsHistoryLimit int32 = 25
+
+// GetDefaultImage returns the image of patch jobs configured for the cluster
+func GetDefaultImage() string {
+	return Default(os.Getenv("DEFAULT_IMAGE"), DefaultImage)
+}
+
+// GetRunnerImage returns the image of the jobs that run patches with the
+// runner. it is the image of the operator pod unless a runner image is configured
+func GetRunnerImage() string {
+	return Default(os.Getenv("RUNNER_IMAGE"), Default(operatorImage, DefaultRunnerImage))
+}
+
+// LookupOperatorImage looks up the image of the operator pod named by the
+// POD_NAME and POD_NAMESPACE environment variables, so the runner always runs
+// the same version as the operator
+func LookupOperatorImage(ctx context.Context, reader client.Reader) error {
+	name := os.Getenv("POD_NAME")
+	namespace := os.Getenv("POD_NAMESPACE")
+	if name == "" || namespace == "" {
+		return nil
+	}
+	pod := &v1.Pod{}
+	if err := reader.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, pod); err != nil {
+		return err
+	}
+	operatorImage = podOperatorImage(pod)
+	return nil
+}
+
+// podOperatorImage returns the image of the operator container of a pod, which
+// is the container running the manager when the pod has sidecars
+func podOperatorImage(pod *v1.Pod) string {
+	containers := pod.Spec.Containers
+	if len(containers) == 0 {
+		return ""
+	}
+	for _, container := range containers {
+		if len(container.Command) > 0 && filepath.Base(container.Command[0]) == "manager" {
+			return container.Image
+		}
+	}
+	return containers[0].Image
+}
+
+// GetDefaultServiceAccountName returns the service account of patches
+// configured for the cluster
+func GetDefaultServiceAccountName() string {
+	return Default(os.Getenv("DEFAULT_SERVICE_ACCOUNT_NAME"), DefaultServiceAccountName)
+}
+
+// DefaultPatchSpec sets the defaults of a patch spec, so the stored patch shows
+// exactly what will run and its spec hash does not change when the defaults of
+// the operator change. targets of namespaced kinds default to the namespace of
+// the patch
+func DefaultPatchSpec(spec *patchv1alpha1.PatchSpec, namespace string, restMapper meta.RESTMapper) {
+	spec.ServiceAccountName = Default(spec.ServiceAccountName, GetDefaultServiceAccountName())
+	DefaultClusterPatchSpec(spec, namespace, restMapper)
+}
+
+// DefaultClusterPatchSpec sets the defaults of a cluster patch spec. the
+// service account is not defaulted, since cluster patches must name the
+// service account they run as
+func DefaultClusterPatchSpec(spec *patchv1alpha1.PatchSpec, namespace string, restMapper meta.RESTMapper) {
+	spec.Image = Default(spec.Image, GetDefaultImage())
+	for i := range spec.Patches {
+		patchItem := &spec.Patches[i]
+		patchItem.Id = Default(patchItem.Id, fmt.Sprint(i))
+		if patchItem.Type == "" {
+			patchItem.Type = patchv1alpha1.StrategicPatchType
+		}
+		if patchItem.Type == patchv1alpha1.ScriptPatchType {
+			continue
+		}
+		DefaultTarget(&patchItem.Target, namespace, restMapper)
+		for j := range patchItem.SkipIf {
+			if patchItem.SkipIf[j].Target != nil {
+				DefaultTarget(patchItem.SkipIf[j].Target, namespace, restMapper)
+			}
+		}
+		for j := range patchItem.Vars {
+			DefaultTarget(&patchItem.Vars[j].Target, namespace, restMapper)
+		}
+	}
+}
+
+// DefaultTarget sets the apiVersion of a target from its group and version and
+// the namespace of a target of a namespaced kind
+func DefaultTarget(target *patchv1alpha1.Target, namespace string, restMapper meta.RESTMapper) {
+	if target.ApiVersion == "" && target.Version != "" {
+		target.ApiVersion = schema.GroupVersion{Group: target.Group, Version: target.Version}.String()
+		target.Group = ""
+		target.Version = ""
+	}
+	if target.Namespace != "" || target.NamespaceSelector != nil || target.ApiVersion == "" {
+		return
+	}
+	gv, err := schema.ParseGroupVersion(target.ApiVersion)
+	if err != nil {
+		return
+	}
+	mapping, err := restMapper.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: target.Kind}, gv.Version)
+	if err != nil {
+		return
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		target.Namespace = namespace
+	}
+}
+
+// LogsHistoryLimit returns the number of configmaps with the logs of previous
+// jobs to keep for a patch
+func LogsHistoryLimit(patch *patchv1alpha1.Patch) int32 {
+	if patch.Spec.LogsHistoryLimit == nil {
+		return DefaultLogsHistoryLimit
+	}
+	return *patch.Spec.LogsHistoryLimit
+}
+
+// SuccessfulRunsHistoryLimit returns the number of successful runs to keep for a patch
+func SuccessfulRunsHistoryLimit(patch *patchv1alpha1.Patch) int32 {
+	if patch.Spec.SuccessfulRunsHistoryLimit == nil {
+		return DefaultSuccessfulRunsHistoryLimit
+	}
+	if *patch.Spec.SuccessfulRunsHistoryLimit > MaxRunsHistoryLimit {
+		return MaxRunsHistoryLimit
+	}
+	return *patch.Spec.SuccessfulRunsHistoryLimit
+}
+
+// FailedRunsHistoryLimit returns the number of failed runs to keep for a patch
+func FailedRunsHistoryLimit(patch *patchv1alpha1.Patch) int32 {
+	if patch.Spec.FailedRunsHistoryLimit == nil {
+		return DefaultFailedRunsHistoryLimit
+	}
+	if *patch.Spec.FailedRunsHistoryLimit > MaxRunsHistoryLimit {
+		return MaxRunsHistoryLimit
+	}
+	return *patch.Spec.FailedRunsHistoryLimit
+}
@@ -0,0 +1,209 @@
+/**
+ * File: /defaults_test.go
+ * Project: util
+ * File Created: 17-10-2026 06:35:19
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDefaultPatchSpec(t *testing.T) {
+	for key, value := range map[string]string{
+		"DEFAULT_IMAGE":                "example.com/kubectl:1.0.0",
+		"DEFAULT_SERVICE_ACCOUNT_NAME": "patcher",
+	} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	restMapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{})
+	restMapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	tests := []struct {
+		name     string
+		patch    patchv1alpha1.PatchSpecPatch
+		expected patchv1alpha1.PatchSpecPatch
+	}{
+		{
+			name:  "namespaced target",
+			patch: patchv1alpha1.PatchSpecPatch{Target: patchv1alpha1.Target{Group: "apps", Version: "v1", Kind: "Deployment", Name: "web"}},
+			expected: patchv1alpha1.PatchSpecPatch{
+				Id:     "0",
+				Type:   patchv1alpha1.StrategicPatchType,
+				Target: patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web", Namespace: "default"},
+			},
+		},
+		{
+			name:  "cluster scoped target",
+			patch: patchv1alpha1.PatchSpecPatch{Id: "ns", Target: patchv1alpha1.Target{Version: "v1", Kind: "Namespace", Name: "default"}},
+			expected: patchv1alpha1.PatchSpecPatch{
+				Id:     "ns",
+				Type:   patchv1alpha1.StrategicPatchType,
+				Target: patchv1alpha1.Target{ApiVersion: "v1", Kind: "Namespace", Name: "default"},
+			},
+		},
+		{
+			name:  "unknown kind",
+			patch: patchv1alpha1.PatchSpecPatch{Type: patchv1alpha1.MergePatchType, Target: patchv1alpha1.Target{ApiVersion: "example.com/v1", Kind: "Widget", Name: "web"}},
+			expected: patchv1alpha1.PatchSpecPatch{
+				Id:     "0",
+				Type:   patchv1alpha1.MergePatchType,
+				Target: patchv1alpha1.Target{ApiVersion: "example.com/v1", Kind: "Widget", Name: "web"},
+			},
+		},
+		{
+			name:  "script",
+			patch: patchv1alpha1.PatchSpecPatch{Type: patchv1alpha1.ScriptPatchType, Target: patchv1alpha1.Target{Group: "apps", Version: "v1", Kind: "Deployment"}},
+			expected: patchv1alpha1.PatchSpecPatch{
+				Id:     "0",
+				Type:   patchv1alpha1.ScriptPatchType,
+				Target: patchv1alpha1.Target{Group: "apps", Version: "v1", Kind: "Deployment"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := &patchv1alpha1.PatchSpec{Patches: []patchv1alpha1.PatchSpecPatch{test.patch}}
+			DefaultPatchSpec(spec, "default", restMapper)
+			if !reflect.DeepEqual(spec.Patches[0], test.expected) {
+				t.Fatalf("expected %+v, got %+v", test.expected, spec.Patches[0])
+			}
+			if spec.Image != "example.com/kubectl:1.0.0" || spec.ServiceAccountName != "patcher" {
+				t.Fatalf("expected the configured image and service account to be stored, got %s and %s", spec.Image, spec.ServiceAccountName)
+			}
+		})
+	}
+}
+
+func TestDefaultPatchSpecKeepsSetValues(t *testing.T) {
+	for key, value := range map[string]string{
+		"DEFAULT_IMAGE":                "example.com/kubectl:1.0.0",
+		"DEFAULT_SERVICE_ACCOUNT_NAME": "patcher",
+	} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	restMapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{})
+	tests := []struct {
+		name               string
+		clusterPatch       bool
+		spec               patchv1alpha1.PatchSpec
+		image              string
+		serviceAccountName string
+	}{
+		{
+			name:               "patch with image and service account",
+			spec:               patchv1alpha1.PatchSpec{Image: "example.com/helm:3.0.0", ServiceAccountName: "deployer"},
+			image:              "example.com/helm:3.0.0",
+			serviceAccountName: "deployer",
+		},
+		{
+			name:         "cluster patch without service account",
+			clusterPatch: true,
+			image:        "example.com/kubectl:1.0.0",
+		},
+		{
+			name:               "cluster patch with service account",
+			clusterPatch:       true,
+			spec:               patchv1alpha1.PatchSpec{ServiceAccountName: "deployer"},
+			image:              "example.com/kubectl:1.0.0",
+			serviceAccountName: "deployer",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := test.spec.DeepCopy()
+			if test.clusterPatch {
+				DefaultClusterPatchSpec(spec, "patch-operator", restMapper)
+			} else {
+				DefaultPatchSpec(spec, "default", restMapper)
+			}
+			if spec.Image != test.image || spec.ServiceAccountName != test.serviceAccountName {
+				t.Fatalf("expected image %q and service account %q, got %q and %q", test.image, test.serviceAccountName, spec.Image, spec.ServiceAccountName)
+			}
+		})
+	}
+}
+
+func TestGetRunnerImage(t *testing.T) {
+	manager := v1.Container{Name: "manager", Image: "example.com/patch-operator:1.2.3", Command: []string{"/manager"}}
+	proxy := v1.Container{Name: "kube-rbac-proxy", Image: "example.com/kube-rbac-proxy:0.8.0"}
+	tests := []struct {
+		name        string
+		runnerImage string
+		pod         *v1.Pod
+		expected    string
+	}{
+		{
+			name:     "outside of the cluster",
+			expected: DefaultRunnerImage,
+		},
+		{
+			name:     "operator pod",
+			pod:      &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Image: "example.com/patch-operator:1.2.3"}}}},
+			expected: "example.com/patch-operator:1.2.3",
+		},
+		{
+			name:     "operator pod with a sidecar",
+			pod:      &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{proxy, manager}}},
+			expected: "example.com/patch-operator:1.2.3",
+		},
+		{
+			name:        "configured runner image",
+			runnerImage: "example.com/patch-operator:debug",
+			pod:         &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{manager}}},
+			expected:    "example.com/patch-operator:debug",
+		},
+	}
+	defer func() {
+		operatorImage = ""
+	}()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Setenv("RUNNER_IMAGE", test.runnerImage)
+			defer os.Unsetenv("RUNNER_IMAGE")
+			operatorImage = ""
+			if test.pod != nil {
+				test.pod.ObjectMeta = metav1.ObjectMeta{Name: "patch-operator-0", Namespace: "patch-operator"}
+				os.Setenv("POD_NAME", test.pod.Name)
+				os.Setenv("POD_NAMESPACE", test.pod.Namespace)
+				defer os.Unsetenv("POD_NAME")
+				defer os.Unsetenv("POD_NAMESPACE")
+				reader := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(test.pod).Build()
+				if err := LookupOperatorImage(context.Background(), reader); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if image := GetRunnerImage(); image != test.expected {
+				t.Fatalf("expected runner image %s, got %s", test.expected, image)
+			}
+		})
+	}
+}
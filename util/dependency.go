@@ -0,0 +1,93 @@
+/**
+ * File: /dependency.go
+ * Project: util
+ * File Created: 17-10-2026 15:36:50
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DependsOnIndexField indexes patches by the patches they depend on, so they
+// are reconciled when a dependency finishes
+const DependsOnIndexField = "spec.dependsOn"
+
+func IndexPatchDependencies(obj client.Object) []string {
+	patch, ok := AsPatch(obj)
+	if !ok {
+		return nil
+	}
+	keys := []string{}
+	for _, dependency := range patch.Spec.DependsOn {
+		keys = append(keys, DependencyNamespacedName(patch, &dependency).String())
+	}
+	return keys
+}
+
+// DependencyNamespacedName returns the name of a dependency, defaulting to the
+// namespace of the patch
+func DependencyNamespacedName(
+	patch *patchv1alpha1.Patch,
+	dependency *patchv1alpha1.NamespacedName,
+) types.NamespacedName {
+	return types.NamespacedName{
+		Name:      dependency.Name,
+		Namespace: Default(dependency.Namespace, patch.GetNamespace()),
+	}
+}
+
+// FindDependencyCycle returns the patches in a dependency cycle that includes
+// the patch or nil if there is no cycle. missing dependencies are ignored
+func FindDependencyCycle(
+	patch *patchv1alpha1.Patch,
+	get func(namespacedName types.NamespacedName) (*patchv1alpha1.Patch, error),
+) ([]types.NamespacedName, error) {
+	start := types.NamespacedName{Name: patch.GetName(), Namespace: patch.GetNamespace()}
+	visited := map[types.NamespacedName]bool{}
+	var visit func(current *patchv1alpha1.Patch, path []types.NamespacedName) ([]types.NamespacedName, error)
+	visit = func(current *patchv1alpha1.Patch, path []types.NamespacedName) ([]types.NamespacedName, error) {
+		for _, dependency := range current.Spec.DependsOn {
+			namespacedName := DependencyNamespacedName(current, &dependency)
+			if namespacedName == start {
+				return append(path, namespacedName), nil
+			}
+			if visited[namespacedName] {
+				continue
+			}
+			visited[namespacedName] = true
+			dependencyPatch, err := get(namespacedName)
+			if err != nil {
+				if k8sErrors.IsNotFound(err) {
+					continue
+				}
+				return nil, err
+			}
+			cycle, err := visit(dependencyPatch, append(path, namespacedName))
+			if err != nil || cycle != nil {
+				return cycle, err
+			}
+		}
+		return nil, nil
+	}
+	return visit(patch, []types.NamespacedName{start})
+}
@@ -0,0 +1,196 @@
+/**
+ * File: /dependency_test.go
+ * Project: util
+ * File Created: 17-10-2026 07:15:23
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newDependentPatch(namespace string, name string, dependsOn ...patchv1alpha1.NamespacedName) *patchv1alpha1.Patch {
+	return &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       patchv1alpha1.PatchSpec{DependsOn: dependsOn},
+	}
+}
+
+func TestIndexPatchDependencies(t *testing.T) {
+	os.Setenv("NAMESPACE", "patch-operator")
+	defer os.Unsetenv("NAMESPACE")
+	tests := []struct {
+		name string
+		obj  client.Object
+		keys []string
+	}{
+		{
+			name: "no dependencies",
+			obj:  newDependentPatch("default", "web"),
+			keys: []string{},
+		},
+		{
+			name: "dependency in the namespace of the patch",
+			obj:  newDependentPatch("default", "web", patchv1alpha1.NamespacedName{Name: "crds"}),
+			keys: []string{"default/crds"},
+		},
+		{
+			name: "cross namespace dependency",
+			obj: newDependentPatch("default", "web",
+				patchv1alpha1.NamespacedName{Name: "crds"},
+				patchv1alpha1.NamespacedName{Name: "issuer", Namespace: "cert-manager"},
+			),
+			keys: []string{"default/crds", "cert-manager/issuer"},
+		},
+		{
+			name: "cluster patch dependency in the operator namespace",
+			obj: &patchv1alpha1.ClusterPatch{
+				ObjectMeta: metav1.ObjectMeta{Name: "web"},
+				Spec:       patchv1alpha1.PatchSpec{DependsOn: []patchv1alpha1.NamespacedName{{Name: "crds"}}},
+			},
+			keys: []string{"patch-operator/crds"},
+		},
+		{
+			name: "not a patch",
+			obj:  &metav1.PartialObjectMetadata{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if keys := IndexPatchDependencies(test.obj); !reflect.DeepEqual(keys, test.keys) {
+				t.Fatalf("expected keys %v, got %v", test.keys, keys)
+			}
+		})
+	}
+}
+
+func TestFindDependencyCycle(t *testing.T) {
+	a := patchv1alpha1.NamespacedName{Name: "a"}
+	b := patchv1alpha1.NamespacedName{Name: "b"}
+	c := patchv1alpha1.NamespacedName{Name: "c"}
+	d := patchv1alpha1.NamespacedName{Name: "d"}
+	key := func(namespace string, name string) types.NamespacedName {
+		return types.NamespacedName{Name: name, Namespace: namespace}
+	}
+	tests := []struct {
+		name    string
+		patch   *patchv1alpha1.Patch
+		patches []*patchv1alpha1.Patch
+		getErr  error
+		cycle   []types.NamespacedName
+		err     string
+	}{
+		{
+			name:  "self dependency",
+			patch: newDependentPatch("default", "a", a),
+			cycle: []types.NamespacedName{key("default", "a"), key("default", "a")},
+		},
+		{
+			name:    "two patch cycle",
+			patch:   newDependentPatch("default", "a", b),
+			patches: []*patchv1alpha1.Patch{newDependentPatch("default", "b", a)},
+			cycle:   []types.NamespacedName{key("default", "a"), key("default", "b"), key("default", "a")},
+		},
+		{
+			name:  "diamond without a cycle",
+			patch: newDependentPatch("default", "a", b, c),
+			patches: []*patchv1alpha1.Patch{
+				newDependentPatch("default", "b", d),
+				newDependentPatch("default", "c", d),
+				newDependentPatch("default", "d"),
+			},
+		},
+		{
+			name:  "missing dependency ignored",
+			patch: newDependentPatch("default", "a", b, c),
+			patches: []*patchv1alpha1.Patch{
+				newDependentPatch("default", "c", a),
+			},
+			cycle: []types.NamespacedName{key("default", "a"), key("default", "c"), key("default", "a")},
+		},
+		{
+			name: "cross namespace cycle",
+			patch: newDependentPatch("default", "a",
+				patchv1alpha1.NamespacedName{Name: "b", Namespace: "other"},
+			),
+			patches: []*patchv1alpha1.Patch{
+				newDependentPatch("other", "b", patchv1alpha1.NamespacedName{Name: "a", Namespace: "default"}),
+				newDependentPatch("default", "b", a),
+			},
+			cycle: []types.NamespacedName{key("default", "a"), key("other", "b"), key("default", "a")},
+		},
+		{
+			name: "cross namespace dependency defaults to its own namespace",
+			patch: newDependentPatch("default", "a",
+				patchv1alpha1.NamespacedName{Name: "b", Namespace: "other"},
+			),
+			patches: []*patchv1alpha1.Patch{
+				newDependentPatch("other", "b", a),
+				newDependentPatch("other", "a"),
+			},
+		},
+		{
+			name:   "get error propagated",
+			patch:  newDependentPatch("default", "a", b),
+			getErr: errors.New("connection refused"),
+			err:    "connection refused",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patches := map[types.NamespacedName]*patchv1alpha1.Patch{}
+			for _, patch := range test.patches {
+				patches[key(patch.GetNamespace(), patch.GetName())] = patch
+			}
+			cycle, err := FindDependencyCycle(test.patch, func(namespacedName types.NamespacedName) (*patchv1alpha1.Patch, error) {
+				if test.getErr != nil {
+					return nil, test.getErr
+				}
+				patch, ok := patches[namespacedName]
+				if !ok {
+					return nil, k8sErrors.NewNotFound(schema.GroupResource{Group: "patch.rock8s.com", Resource: "patches"}, namespacedName.Name)
+				}
+				return patch, nil
+			})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cycle, test.cycle) {
+				t.Fatalf("expected cycle %v, got %v", test.cycle, cycle)
+			}
+		})
+	}
+}
@@ -0,0 +1,602 @@
+/**
+ * File: /engine.go
+ * Project: util
+ * File Created: 17-10-2026 09:12:41
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ResolvedPatch is a patch with a target that matches exactly one resource
+type ResolvedPatch struct {
+	Id        string
+	PatchItem *patchv1alpha1.PatchSpecPatch
+}
+
+// EngineUtil applies patches directly from the operator instead of
+// rendering a script and running it in a job
+type EngineUtil struct {
+	ctx         *context.Context
+	kubectlUtil *KubectlUtil
+	patch       *patchv1alpha1.Patch
+}
+
+func NewEngineUtil(patch *patchv1alpha1.Patch, kubectlUtil *KubectlUtil, ctx *context.Context) *EngineUtil {
+	return newEngineUtil(patch, kubectlUtil.ForServiceAccount(
+		patch.GetNamespace(),
+		Default(patch.Spec.ServiceAccountName, GetDefaultServiceAccountName()),
+	), ctx)
+}
+
+// newEngineUtil creates an engine util that does not impersonate the service
+// account of the patch, which is used by the runner that runs as the service account
+func newEngineUtil(patch *patchv1alpha1.Patch, kubectlUtil *KubectlUtil, ctx *context.Context) *EngineUtil {
+	kubectlUtil = kubectlUtil.WithContext(ctx)
+	kubectlUtil.SetFieldManager(patch.Spec.FieldManager, patch.Spec.Force)
+	return &EngineUtil{
+		ctx:         ctx,
+		kubectlUtil: kubectlUtil,
+		patch:       patch,
+	}
+}
+
+// Resolve expands the patches with targets that match multiple resources into
+// a patch for every matched resource
+func (e *EngineUtil) Resolve() ([]ResolvedPatch, error) {
+	resolvedPatches := []ResolvedPatch{}
+	for i, patchItem := range e.patch.Spec.Patches {
+		patchId := patchItem.Id
+		if patchId == "" {
+			patchId = fmt.Sprint(i)
+		}
+		content, err := e.content(patchId, &patchItem)
+		if err != nil {
+			return nil, err
+		}
+		if len(patchItem.Vars) > 0 {
+			vars, err := e.vars(patchId, &patchItem)
+			if err != nil {
+				return nil, err
+			}
+			content, err = RenderPatch(patchId, content, vars)
+			if err != nil {
+				return nil, err
+			}
+		}
+		patchItem.Patch = content
+		if patchItem.Type == patchv1alpha1.ScriptPatchType || !IsMultiTarget(&patchItem.Target) {
+			resolvedPatches = append(resolvedPatches, ResolvedPatch{
+				Id:        patchId,
+				PatchItem: patchItem.DeepCopy(),
+			})
+			continue
+		}
+		objs, err := e.match(patchId, &patchItem.Target)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			resolvedPatchItem := patchItem.DeepCopy()
+			resolvedPatchItem.Target = patchv1alpha1.Target{
+				ApiVersion: obj.GetAPIVersion(),
+				Kind:       obj.GetKind(),
+				Name:       obj.GetName(),
+				Namespace:  obj.GetNamespace(),
+			}
+			resolvedPatches = append(resolvedPatches, ResolvedPatch{
+				Id:        patchId,
+				PatchItem: resolvedPatchItem,
+			})
+		}
+	}
+	return resolvedPatches, nil
+}
+
+// PatchFromContents returns the contents of the configmap and secret keys the
+// patches are read from. missing keys are empty, so they change once created
+func (e *EngineUtil) PatchFromContents() ([]string, error) {
+	contents := []string{}
+	for _, patchItem := range e.patch.Spec.Patches {
+		kind, keyRef := PatchFromKeyRef(&patchItem)
+		if keyRef == nil {
+			continue
+		}
+		content, _, err := e.readKeyRef(kind, keyRef)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, content)
+	}
+	return contents, nil
+}
+
+// content returns the patch of a patch item, reading it from a configmap or
+// secret when the patch is not inline
+func (e *EngineUtil) content(patchId string, patchItem *patchv1alpha1.PatchSpecPatch) (string, error) {
+	kind, keyRef := PatchFromKeyRef(patchItem)
+	if keyRef == nil {
+		return patchItem.Patch, nil
+	}
+	content, found, err := e.readKeyRef(kind, keyRef)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("patch %s failed to read %s: %s", patchId, strings.ToLower(kind), err.Error()))
+	}
+	if !found {
+		return "", errors.New(fmt.Sprintf("patch %s %s %s does not have key %s", patchId, strings.ToLower(kind), keyRef.Name, keyRef.Key))
+	}
+	return content, nil
+}
+
+// vars reads the values of the vars of a patch item from their targets
+func (e *EngineUtil) vars(patchId string, patchItem *patchv1alpha1.PatchSpecPatch) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, patchVar := range patchItem.Vars {
+		obj, err := e.get(patchId, &patchVar.Target)
+		if err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			return nil, errors.New(fmt.Sprintf("patch %s var %s target does not exist", patchId, patchVar.Name))
+		}
+		value, err := e.evalJsonPath(obj, patchVar.JsonPath)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("patch %s var %s failed: %s", patchId, patchVar.Name, err.Error()))
+		}
+		vars[patchVar.Name] = value
+	}
+	return vars, nil
+}
+
+// readKeyRef reads a key of a configmap or secret as the service account of the patch
+func (e *EngineUtil) readKeyRef(kind string, keyRef *patchv1alpha1.KeyRef) (string, bool, error) {
+	namespace, err := PatchFromNamespace(e.patch, keyRef)
+	if err != nil {
+		return "", false, err
+	}
+	obj, err := e.get("", &patchv1alpha1.Target{
+		ApiVersion: "v1",
+		Kind:       kind,
+		Name:       keyRef.Name,
+		Namespace:  namespace,
+	})
+	if err != nil || obj == nil {
+		return "", false, err
+	}
+	if kind == ConfigMapKind {
+		if value, found, _ := unstructured.NestedString(obj.Object, "data", keyRef.Key); found {
+			return value, true, nil
+		}
+	}
+	value, found, _ := unstructured.NestedString(obj.Object, "binaryData", keyRef.Key)
+	if kind == SecretKind {
+		value, found, _ = unstructured.NestedString(obj.Object, "data", keyRef.Key)
+	}
+	if !found {
+		return "", false, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", false, err
+	}
+	return string(decoded), true, nil
+}
+
+// match lists the resources matched by a target
+func (e *EngineUtil) match(patchId string, target *patchv1alpha1.Target) ([]unstructured.Unstructured, error) {
+	resource, err := e.kubectlUtil.TargetToResource(patchId, e.patch, target)
+	if err != nil {
+		return nil, err
+	}
+	resource.SetName("")
+	var namespaces map[string]bool
+	if target.Namespace == "" && target.NamespaceSelector != nil {
+		resource.SetNamespace("")
+		namespaces, err = e.matchNamespaces(target.NamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	labelSelector := ""
+	if target.LabelSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(target.LabelSelector)
+		if err != nil {
+			return nil, err
+		}
+		labelSelector = selector.String()
+	}
+	body, err := json.Marshal(resource.Object)
+	if err != nil {
+		return nil, err
+	}
+	list, err := e.kubectlUtil.List(body, labelSelector)
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return []unstructured.Unstructured{}, nil
+		}
+		return nil, err
+	}
+	objs := []unstructured.Unstructured{}
+	for _, obj := range list.Items {
+		if !TargetMatchesName(target, obj.GetName()) {
+			continue
+		}
+		if namespaces != nil && obj.GetNamespace() != "" && !namespaces[obj.GetNamespace()] {
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+func (e *EngineUtil) matchNamespaces(namespaceSelector *metav1.LabelSelector) (map[string]bool, error) {
+	selector, err := metav1.LabelSelectorAsSelector(namespaceSelector)
+	if err != nil {
+		return nil, err
+	}
+	resource := unstructured.Unstructured{}
+	resource.SetAPIVersion("v1")
+	resource.SetKind("Namespace")
+	body, err := json.Marshal(resource.Object)
+	if err != nil {
+		return nil, err
+	}
+	list, err := e.kubectlUtil.List(body, selector.String())
+	if err != nil {
+		return nil, err
+	}
+	namespaces := map[string]bool{}
+	for _, namespace := range list.Items {
+		namespaces[namespace.GetName()] = true
+	}
+	return namespaces, nil
+}
+
+// Waiting returns the ids of the patches with targets that should be waited
+// for and do not exist yet. all targets are checked before anything is applied,
+// so a patch is never partially applied while waiting
+func (e *EngineUtil) Waiting() ([]string, error) {
+	waiting := []string{}
+	for i, patchItem := range e.patch.Spec.Patches {
+		if !patchItem.WaitForResource || IsMultiTarget(&patchItem.Target) {
+			continue
+		}
+		patchId := patchItem.Id
+		if patchId == "" {
+			patchId = fmt.Sprint(i)
+		}
+		obj, err := e.get(patchId, &patchItem.Target)
+		if err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			waiting = append(waiting, patchId)
+		}
+	}
+	return waiting, nil
+}
+
+// Apply applies a single patch item and returns the patched target, or the
+// reason the patch was skipped. the caller waits for the timeout of the patch
+func (e *EngineUtil) Apply(patchId string, patchItem *patchv1alpha1.PatchSpecPatch) (*unstructured.Unstructured, string, error) {
+	if patchItem.Type == patchv1alpha1.ScriptPatchType {
+		return nil, "", errors.New(fmt.Sprintf(
+			"patch %s is a script patch which requires the %s executor",
+			patchId, patchv1alpha1.JobExecutor,
+		))
+	}
+	reason, err := e.skip(patchId, patchItem)
+	if err != nil {
+		return nil, "", err
+	}
+	if reason != "" {
+		return nil, reason, nil
+	}
+	resource, err := e.kubectlUtil.TargetToResource(patchId, e.patch, &patchItem.Target)
+	if err != nil {
+		return nil, "", err
+	}
+	body, err := json.Marshal(resource.Object)
+	if err != nil {
+		return nil, "", err
+	}
+	patchType := PatchType(patchItem.Type)
+	if patchType == "" {
+		patchType = StrategicPatchType
+	}
+	patched, err := e.kubectlUtil.Patch(body, patchType, []byte(patchItem.Patch))
+	if err != nil {
+		return nil, "", e.patchError(patchId, patchItem, err)
+	}
+	return patched, "", nil
+}
+
+// ResourceVersion returns the resource version of a target or an empty string
+// if it does not exist
+func (e *EngineUtil) ResourceVersion(patchId string, target *patchv1alpha1.Target) (string, error) {
+	obj, err := e.get(patchId, target)
+	if err != nil || obj == nil {
+		return "", err
+	}
+	return obj.GetResourceVersion(), nil
+}
+
+// Drifted returns true if applying the patches again would change any target
+func (e *EngineUtil) Drifted() (bool, error) {
+	resolvedPatches, err := e.Resolve()
+	if err != nil {
+		return false, err
+	}
+	for _, resolvedPatch := range resolvedPatches {
+		patchId := resolvedPatch.Id
+		patchItem := resolvedPatch.PatchItem
+		if patchItem.Type == patchv1alpha1.ScriptPatchType || IsDryRun(e.patch, patchItem) {
+			continue
+		}
+		reason, err := e.skip(patchId, patchItem)
+		if err != nil {
+			return false, err
+		}
+		if reason != "" {
+			continue
+		}
+		live, patched, err := e.preview(patchId, patchItem)
+		if err != nil {
+			return false, err
+		}
+		if live != nil && !e.equivalent(live, patched) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DryRun returns a json merge patch of the changes a patch would make to its
+// target, or the reason the patch would be skipped
+func (e *EngineUtil) DryRun(patchId string, patchItem *patchv1alpha1.PatchSpecPatch) (string, string, error) {
+	if patchItem.Type == patchv1alpha1.ScriptPatchType {
+		return "", "", errors.New(fmt.Sprintf("patch %s is a script patch which cannot be dry run", patchId))
+	}
+	reason, err := e.skip(patchId, patchItem)
+	if err != nil {
+		return "", "", err
+	}
+	if reason != "" {
+		return "", reason, nil
+	}
+	live, patched, err := e.preview(patchId, patchItem)
+	if err != nil {
+		return "", "", err
+	}
+	if live == nil {
+		return "", "", errors.New(fmt.Sprintf("patch %s target does not exist", patchId))
+	}
+	diff, err := e.diff(live, patched)
+	if err != nil {
+		return "", "", err
+	}
+	return string(diff), "", nil
+}
+
+// Snapshot returns a json merge patch that reverts the changes a patch would
+// make to its target or nil if the patch would not change the target
+func (e *EngineUtil) Snapshot(patchId string, patchItem *patchv1alpha1.PatchSpecPatch) ([]byte, error) {
+	if patchItem.Type == patchv1alpha1.ScriptPatchType {
+		return nil, nil
+	}
+	reason, err := e.skip(patchId, patchItem)
+	if err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		return nil, nil
+	}
+	live, patched, err := e.preview(patchId, patchItem)
+	if err != nil {
+		return nil, err
+	}
+	if live == nil {
+		return nil, nil
+	}
+	revert, err := e.diff(patched, live)
+	if err != nil {
+		return nil, err
+	}
+	if string(revert) == "{}" {
+		return nil, nil
+	}
+	return revert, nil
+}
+
+// preview returns the target and the target as it would be after the patch
+// using a server side dry run. the target is nil if it does not exist
+func (e *EngineUtil) preview(
+	patchId string,
+	patchItem *patchv1alpha1.PatchSpecPatch,
+) (*unstructured.Unstructured, *unstructured.Unstructured, error) {
+	live, err := e.get(patchId, &patchItem.Target)
+	if err != nil {
+		return nil, nil, err
+	}
+	if live == nil {
+		return nil, nil, nil
+	}
+	body, err := json.Marshal(live.Object)
+	if err != nil {
+		return nil, nil, err
+	}
+	patchType := PatchType(patchItem.Type)
+	if patchType == "" {
+		patchType = StrategicPatchType
+	}
+	patched, err := e.kubectlUtil.PatchDryRun(body, patchType, []byte(patchItem.Patch))
+	if err != nil {
+		return nil, nil, e.patchError(patchId, patchItem, err)
+	}
+	return live, patched, nil
+}
+
+// patchError returns the error of a failed patch. the api server echoes the
+// invalid values of a patch in its errors, so only the reason is returned for
+// patches rendered from a secret
+func (e *EngineUtil) patchError(patchId string, patchItem *patchv1alpha1.PatchSpecPatch, err error) error {
+	if !IsSecretPatch(patchItem) {
+		return errors.New(fmt.Sprintf("patch %s failed: %s", patchId, err.Error()))
+	}
+	reason := k8sErrors.ReasonForError(err)
+	if reason == metav1.StatusReasonUnknown {
+		return errors.New(fmt.Sprintf("patch %s failed: # redacted", patchId))
+	}
+	return errors.New(fmt.Sprintf("patch %s failed: %s # redacted", patchId, reason))
+}
+
+// diff returns a json merge patch that changes a resource from one version to another
+func (e *EngineUtil) diff(from *unstructured.Unstructured, to *unstructured.Unstructured) ([]byte, error) {
+	fromJson, err := json.Marshal(e.clean(from).Object)
+	if err != nil {
+		return nil, err
+	}
+	toJson, err := json.Marshal(e.clean(to).Object)
+	if err != nil {
+		return nil, err
+	}
+	return jsonpatch.CreateMergePatch(fromJson, toJson)
+}
+
+// Revert applies a snapshot to its resource
+func (e *EngineUtil) Revert(snapshot *patchv1alpha1.PatchStatusSnapshot) error {
+	resource := unstructured.Unstructured{}
+	resource.SetAPIVersion(snapshot.ApiVersion)
+	resource.SetKind(snapshot.Kind)
+	resource.SetName(snapshot.Name)
+	resource.SetNamespace(snapshot.Namespace)
+	body, err := json.Marshal(resource.Object)
+	if err != nil {
+		return err
+	}
+	if _, err := e.kubectlUtil.Patch(body, MergePatchType, []byte(snapshot.Revert)); err != nil {
+		if k8sErrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// skip returns the first of the skip criteria that is met or an empty string
+func (e *EngineUtil) skip(patchId string, patchItem *patchv1alpha1.PatchSpecPatch) (string, error) {
+	for _, skipIf := range patchItem.SkipIf {
+		target := skipIf.Target
+		if target == nil {
+			target = &patchItem.Target
+		}
+		obj, err := e.get(patchId, target)
+		if err != nil {
+			return "", err
+		}
+		if obj == nil {
+			continue
+		}
+		value, err := e.evalJsonPath(obj, skipIf.JsonPath)
+		if err != nil {
+			return "", err
+		}
+		regex := ".*"
+		if skipIf.Regex != "" {
+			regex = skipIf.Regex
+		}
+		re, err := regexp.Compile(regex)
+		if err != nil {
+			return "", err
+		}
+		if re.MatchString(value) {
+			return fmt.Sprintf("%s matched %s", Default(skipIf.JsonPath, "."), regex), nil
+		}
+	}
+	return "", nil
+}
+
+// get returns the target or nil if it does not exist
+func (e *EngineUtil) get(patchId string, target *patchv1alpha1.Target) (*unstructured.Unstructured, error) {
+	resource, err := e.kubectlUtil.TargetToResource(patchId, e.patch, target)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(resource.Object)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := e.kubectlUtil.Get(body)
+	if err != nil {
+		if k8sErrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+// equivalent compares two versions of a resource ignoring server managed metadata
+func (e *EngineUtil) equivalent(a *unstructured.Unstructured, b *unstructured.Unstructured) bool {
+	return equality.Semantic.DeepEqual(e.clean(a).Object, e.clean(b).Object)
+}
+
+// clean returns a copy of the resource without server managed metadata
+func (e *EngineUtil) clean(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	obj = obj.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "generation")
+	return obj
+}
+
+func (e *EngineUtil) evalJsonPath(obj *unstructured.Unstructured, path string) (string, error) {
+	if path == "" || path == "." {
+		body, err := json.Marshal(obj.Object)
+		if err != nil {
+			return "", err
+		}
+		return string(body), nil
+	}
+	j, err := ParseJsonPath(path)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	if err := j.Execute(buf, obj.Object); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
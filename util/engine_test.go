@@ -0,0 +1,413 @@
+/**
+ * File: /engine_test.go
+ * Project: util
+ * File Created: 17-10-2026 07:16:41
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var deploymentResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+func newTestDeployment(replicas int64, paused bool) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":      "web",
+			"namespace": "default",
+		},
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+			"paused":   paused,
+		},
+	}}
+}
+
+// dryRunDynamicClient is a fake dynamic client that supports server side dry
+// runs of merge patches, which the fake client would persist
+type dryRunDynamicClient struct {
+	*dynamicfake.FakeDynamicClient
+}
+
+func (c *dryRunDynamicClient) Resource(resource schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &dryRunResourceClient{NamespaceableResourceInterface: c.FakeDynamicClient.Resource(resource)}
+}
+
+type dryRunResourceClient struct {
+	dynamic.NamespaceableResourceInterface
+	namespaced dynamic.ResourceInterface
+}
+
+func (c *dryRunResourceClient) Namespace(namespace string) dynamic.ResourceInterface {
+	return &dryRunResourceClient{
+		NamespaceableResourceInterface: c.NamespaceableResourceInterface,
+		namespaced:                     c.NamespaceableResourceInterface.Namespace(namespace),
+	}
+}
+
+func (c *dryRunResourceClient) resource() dynamic.ResourceInterface {
+	if c.namespaced != nil {
+		return c.namespaced
+	}
+	return c.NamespaceableResourceInterface
+}
+
+func (c *dryRunResourceClient) Get(
+	ctx context.Context,
+	name string,
+	options metav1.GetOptions,
+	subresources ...string,
+) (*unstructured.Unstructured, error) {
+	return c.resource().Get(ctx, name, options, subresources...)
+}
+
+func (c *dryRunResourceClient) Patch(
+	ctx context.Context,
+	name string,
+	pt types.PatchType,
+	data []byte,
+	options metav1.PatchOptions,
+	subresources ...string,
+) (*unstructured.Unstructured, error) {
+	if len(options.DryRun) == 0 {
+		return c.resource().Patch(ctx, name, pt, data, options, subresources...)
+	}
+	if pt != types.MergePatchType {
+		return nil, errors.New(fmt.Sprintf("dry run of %s patches is not supported", pt))
+	}
+	live, err := c.resource().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(live.Object)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := jsonpatch.MergePatch(body, data)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(patched); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// newTestEngineUtil creates an engine util for the patch items backed by a
+// fake dynamic client with the objects
+func newTestEngineUtil(
+	dyn dynamic.Interface,
+	patchItems ...patchv1alpha1.PatchSpecPatch,
+) *EngineUtil {
+	ctx := context.Background()
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "patch", Namespace: "default"},
+		Spec:       patchv1alpha1.PatchSpec{Patches: patchItems},
+	}
+	return newEngineUtil(patch, NewKubectlUtil(&rest.Config{}, newStaticRESTMapper(), dyn), &ctx)
+}
+
+func getTestDeployment(t *testing.T, dyn dynamic.Interface) *unstructured.Unstructured {
+	obj, err := dyn.Resource(deploymentResource).Namespace("default").Get(context.Background(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestEngineApply(t *testing.T) {
+	deploymentTarget := patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web"}
+	tests := []struct {
+		name     string
+		paused   bool
+		item     patchv1alpha1.PatchSpecPatch
+		replicas int64
+		reason   string
+		err      string
+	}{
+		{
+			name:     "applies the patch",
+			item:     patchv1alpha1.PatchSpecPatch{Type: patchv1alpha1.MergePatchType, Patch: "spec:\n  replicas: 3\n"},
+			replicas: 3,
+		},
+		{
+			name:   "skipIf matches",
+			paused: true,
+			item: patchv1alpha1.PatchSpecPatch{
+				Type:   patchv1alpha1.MergePatchType,
+				Patch:  "spec:\n  replicas: 3\n",
+				SkipIf: []patchv1alpha1.PatchSpecPatchSkipIf{{JsonPath: ".spec.paused", Regex: "true"}},
+			},
+			replicas: 1,
+			reason:   ".spec.paused matched true",
+		},
+		{
+			name: "skipIf does not match",
+			item: patchv1alpha1.PatchSpecPatch{
+				Type:   patchv1alpha1.MergePatchType,
+				Patch:  "spec:\n  replicas: 3\n",
+				SkipIf: []patchv1alpha1.PatchSpecPatchSkipIf{{JsonPath: ".spec.paused", Regex: "true"}},
+			},
+			replicas: 3,
+		},
+		{
+			name: "skipIf target does not exist",
+			item: patchv1alpha1.PatchSpecPatch{
+				Type:  patchv1alpha1.MergePatchType,
+				Patch: "spec:\n  replicas: 3\n",
+				SkipIf: []patchv1alpha1.PatchSpecPatchSkipIf{{
+					Target: &patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "api"},
+				}},
+			},
+			replicas: 3,
+		},
+		{
+			name:     "script patch",
+			item:     patchv1alpha1.PatchSpecPatch{Type: patchv1alpha1.ScriptPatchType, Patch: "kubectl get pods\n"},
+			replicas: 1,
+			err:      "requires the job executor",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dyn := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newTestDeployment(1, test.paused))
+			test.item.Target = deploymentTarget
+			engineUtil := newTestEngineUtil(dyn, test.item)
+			patched, reason, err := engineUtil.Apply("0", &test.item)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if reason != test.reason {
+				t.Fatalf("expected skip reason %q, got %q", test.reason, reason)
+			}
+			if (patched == nil) != (test.reason != "" || test.err != "") {
+				t.Fatalf("expected the patched target only when the patch was applied, got %v", patched)
+			}
+			replicas, _, _ := unstructured.NestedInt64(getTestDeployment(t, dyn).Object, "spec", "replicas")
+			if replicas != test.replicas {
+				t.Fatalf("expected %d replicas, got %d", test.replicas, replicas)
+			}
+		})
+	}
+}
+
+func TestEngineRevert(t *testing.T) {
+	dyn := &dryRunDynamicClient{
+		FakeDynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newTestDeployment(1, false)),
+	}
+	patchItem := patchv1alpha1.PatchSpecPatch{
+		Type:   patchv1alpha1.MergePatchType,
+		Target: patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+		Patch:  "metadata:\n  labels:\n    team: payments\nspec:\n  replicas: 3\n  paused: true\n",
+	}
+	engineUtil := newTestEngineUtil(dyn, patchItem)
+	original := getTestDeployment(t, dyn)
+	revert, err := engineUtil.Snapshot("0", &patchItem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revert == nil {
+		t.Fatal("expected a snapshot of the fields the patch changes")
+	}
+	if !engineUtil.equivalent(getTestDeployment(t, dyn), original) {
+		t.Fatal("expected the snapshot not to change the target")
+	}
+	if _, _, err := engineUtil.Apply("0", &patchItem); err != nil {
+		t.Fatal(err)
+	}
+	if engineUtil.equivalent(getTestDeployment(t, dyn), original) {
+		t.Fatal("expected the patch to change the target")
+	}
+	if err := engineUtil.Revert(&patchv1alpha1.PatchStatusSnapshot{
+		ApiVersion: "apps/v1",
+		Kind:       "Deployment",
+		Name:       "web",
+		Namespace:  "default",
+		Revert:     string(revert),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if reverted := getTestDeployment(t, dyn); !engineUtil.equivalent(reverted, original) {
+		t.Fatalf("expected the revert to restore %v, got %v", original.Object, reverted.Object)
+	}
+	unchanged, err := engineUtil.Snapshot("0", &patchv1alpha1.PatchSpecPatch{
+		Type:   patchv1alpha1.MergePatchType,
+		Target: patchItem.Target,
+		Patch:  "spec:\n  replicas: 1\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged != nil {
+		t.Fatalf("expected no snapshot for a patch that does not change the target, got %s", string(unchanged))
+	}
+}
+
+func TestEngineDryRun(t *testing.T) {
+	deploymentTarget := patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web"}
+	tests := []struct {
+		name   string
+		paused bool
+		item   patchv1alpha1.PatchSpecPatch
+		diff   string
+		reason string
+		err    string
+	}{
+		{
+			name: "reports the diff",
+			item: patchv1alpha1.PatchSpecPatch{Type: patchv1alpha1.MergePatchType, Target: deploymentTarget, Patch: "spec:\n  replicas: 3\n"},
+			diff: `{"spec":{"replicas":3}}`,
+		},
+		{
+			name: "no changes",
+			item: patchv1alpha1.PatchSpecPatch{Type: patchv1alpha1.MergePatchType, Target: deploymentTarget, Patch: "spec:\n  replicas: 1\n"},
+			diff: "{}",
+		},
+		{
+			name:   "skipIf matches",
+			paused: true,
+			item: patchv1alpha1.PatchSpecPatch{
+				Type:   patchv1alpha1.MergePatchType,
+				Target: deploymentTarget,
+				Patch:  "spec:\n  replicas: 3\n",
+				SkipIf: []patchv1alpha1.PatchSpecPatchSkipIf{{JsonPath: ".spec.paused", Regex: "true"}},
+			},
+			reason: ".spec.paused matched true",
+		},
+		{
+			name: "target does not exist",
+			item: patchv1alpha1.PatchSpecPatch{
+				Type:   patchv1alpha1.MergePatchType,
+				Target: patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "api"},
+				Patch:  "spec:\n  replicas: 3\n",
+			},
+			err: "patch 0 target does not exist",
+		},
+		{
+			name: "script patch",
+			item: patchv1alpha1.PatchSpecPatch{Type: patchv1alpha1.ScriptPatchType, Target: deploymentTarget, Patch: "kubectl get pods\n"},
+			err:  "cannot be dry run",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dyn := &dryRunDynamicClient{
+				FakeDynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newTestDeployment(1, test.paused)),
+			}
+			engineUtil := newTestEngineUtil(dyn, test.item)
+			original := getTestDeployment(t, dyn)
+			diff, reason, err := engineUtil.DryRun("0", &test.item)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if diff != test.diff {
+				t.Fatalf("expected diff %s, got %s", test.diff, diff)
+			}
+			if reason != test.reason {
+				t.Fatalf("expected skip reason %q, got %q", test.reason, reason)
+			}
+			if live := getTestDeployment(t, dyn); !engineUtil.equivalent(live, original) {
+				t.Fatalf("expected the dry run not to change the target, got %v", live.Object)
+			}
+			for _, action := range dyn.Actions() {
+				if action.GetVerb() == "patch" {
+					t.Fatalf("expected the target not to be patched, got %v", action)
+				}
+			}
+		})
+	}
+}
+
+func TestEngineRedactsSecretPatchErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		vars     []patchv1alpha1.PatchSpecPatchVar
+		redacted bool
+	}{
+		{name: "inline patch"},
+		{
+			name: "var from secret",
+			vars: []patchv1alpha1.PatchSpecPatchVar{{
+				Name:     "image",
+				Target:   patchv1alpha1.Target{ApiVersion: "v1", Kind: "Secret", Name: "registry"},
+				JsonPath: ".data.image",
+			}},
+			redacted: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dyn := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newTestDeployment(1, false))
+			dyn.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, k8sErrors.NewInvalid(
+					schema.GroupKind{Group: "apps", Kind: "Deployment"},
+					"web",
+					field.ErrorList{field.Invalid(field.NewPath("spec", "replicas"), "hunter2", "must be an integer")},
+				)
+			})
+			patchItem := patchv1alpha1.PatchSpecPatch{
+				Type:   patchv1alpha1.MergePatchType,
+				Target: patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+				Patch:  "spec:\n  replicas: hunter2\n",
+				Vars:   test.vars,
+			}
+			_, _, err := newTestEngineUtil(dyn, patchItem).Apply("0", &patchItem)
+			if err == nil {
+				t.Fatal("expected the patch to fail")
+			}
+			if redacted := !strings.Contains(err.Error(), "hunter2"); redacted != test.redacted {
+				t.Fatalf("expected the error to be redacted to be %t, got %s", test.redacted, err.Error())
+			}
+			if test.redacted && err.Error() != "patch 0 failed: Invalid # redacted" {
+				t.Fatalf("expected the reason of the error to be kept, got %s", err.Error())
+			}
+		})
+	}
+}
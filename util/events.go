@@ -0,0 +1,92 @@
+/**
+ * File: /events.go
+ * Project: util
+ * File Created: 17-10-2026 19:04:51
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"os"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	JobCreatedReason   = "JobCreated"
+	AppliedReason      = "Applied"
+	SkippedReason      = "Skipped"
+	FailedReason       = "Failed"
+	RecalibratedReason = "Recalibrated"
+	FinalizedReason    = "Finalized"
+	PatchedReason      = "Patched"
+)
+
+// TargetEventsEnabled returns true if events should also be recorded on the
+// patched targets
+func TargetEventsEnabled() bool {
+	return os.Getenv("TARGET_EVENTS") == "true"
+}
+
+// event records an event on the patch
+func (u *PatchUtil) event(
+	patch *patchv1alpha1.Patch,
+	eventType string,
+	reason string,
+	messageFmt string,
+	args ...interface{},
+) {
+	if u.recorder == nil {
+		return
+	}
+	u.recorder.Eventf(u.object(patch), eventType, reason, messageFmt, args...)
+}
+
+// targetEvent records an event on a patched target when target events are enabled
+func (u *PatchUtil) targetEvent(patch *patchv1alpha1.Patch, patchId string, target *unstructured.Unstructured) {
+	if u.recorder == nil || target == nil || !TargetEventsEnabled() {
+		return
+	}
+	kind := "Patch"
+	name := patch.GetNamespace() + "/" + patch.GetName()
+	if u.clusterScoped {
+		kind = "ClusterPatch"
+		name = patch.GetName()
+	}
+	u.recorder.Eventf(target, v1.EventTypeNormal, PatchedReason, "patched by %s %s (patch %s)", kind, name, patchId)
+}
+
+// targetStatusEvent records an event on the target of a target status. the
+// target is read again because jobs do not report the patched targets back
+func (u *PatchUtil) targetStatusEvent(patch *patchv1alpha1.Patch, targetStatus *patchv1alpha1.PatchStatusTarget) {
+	if u.recorder == nil || !TargetEventsEnabled() {
+		return
+	}
+	target, err := NewEngineUtil(patch, u.kubectlUtil, u.ctx).get(targetStatus.Id, &patchv1alpha1.Target{
+		ApiVersion: targetStatus.ApiVersion,
+		Kind:       targetStatus.Kind,
+		Name:       targetStatus.Name,
+		Namespace:  targetStatus.Namespace,
+	})
+	if err != nil {
+		return
+	}
+	u.targetEvent(patch, targetStatus.Id, target)
+}
@@ -0,0 +1,156 @@
+/**
+ * File: /events_test.go
+ * Project: util
+ * File Created: 17-10-2026 07:19:05
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// recordedEvents returns the events recorded by the fake recorder
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestEvent(t *testing.T) {
+	patch := &patchv1alpha1.Patch{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	var c client.Client = fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	tests := []struct {
+		name      string
+		eventType string
+		reason    string
+		message   string
+	}{
+		{name: "job created", eventType: v1.EventTypeNormal, reason: JobCreatedReason, message: "created job web-1"},
+		{name: "applied", eventType: v1.EventTypeNormal, reason: AppliedReason, message: "applied patch 0"},
+		{name: "failed", eventType: v1.EventTypeWarning, reason: FailedReason, message: "patch 0 failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			patchUtil := newTestPatchUtil(t, &c, "web")
+			patchUtil.recorder = recorder
+			patchUtil.event(patch, test.eventType, test.reason, "%s", test.message)
+			expected := test.eventType + " " + test.reason + " " + test.message
+			if events := recordedEvents(recorder); len(events) != 1 || events[0] != expected {
+				t.Fatalf("expected event %q, got %v", expected, events)
+			}
+		})
+	}
+}
+
+func TestTargetEvent(t *testing.T) {
+	patch := &patchv1alpha1.Patch{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	var c client.Client = fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	tests := []struct {
+		name          string
+		targetEvents  string
+		clusterScoped bool
+		noTarget      bool
+		events        []string
+	}{
+		{
+			name:   "target events disabled by default",
+			events: []string{},
+		},
+		{
+			name:         "target events disabled",
+			targetEvents: "false",
+			events:       []string{},
+		},
+		{
+			name:         "patch",
+			targetEvents: "true",
+			events:       []string{"Normal " + PatchedReason + " patched by Patch default/web (patch 0)"},
+		},
+		{
+			name:          "cluster patch",
+			targetEvents:  "true",
+			clusterScoped: true,
+			events:        []string{"Normal " + PatchedReason + " patched by ClusterPatch web (patch 0)"},
+		},
+		{
+			name:         "no target",
+			targetEvents: "true",
+			noTarget:     true,
+			events:       []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Setenv("TARGET_EVENTS", test.targetEvents)
+			defer os.Unsetenv("TARGET_EVENTS")
+			recorder := record.NewFakeRecorder(10)
+			patchUtil := newTestPatchUtil(t, &c, "web")
+			if test.clusterScoped {
+				ctx := context.Background()
+				patchUtil = NewClusterPatchUtil(
+					&c,
+					kubefake.NewSimpleClientset(),
+					NewKubectlUtil(&rest.Config{}, newStaticRESTMapper(), nil),
+					&ctx,
+					&ctrl.Request{NamespacedName: types.NamespacedName{Name: "web"}},
+					newTestScheme(t),
+					log.Log,
+					"web",
+					recorder,
+				)
+			}
+			patchUtil.recorder = recorder
+			target := newTestDeployment(1, false)
+			if test.noTarget {
+				target = nil
+			}
+			patchUtil.targetEvent(patch, "0", target)
+			events := recordedEvents(recorder)
+			if len(events) != len(test.events) {
+				t.Fatalf("expected events %v, got %v", test.events, events)
+			}
+			for i := range events {
+				if events[i] != test.events[i] {
+					t.Fatalf("expected event %q, got %q", test.events[i], events[i])
+				}
+			}
+		})
+	}
+}
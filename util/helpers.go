@@ -23,14 +23,17 @@ package util
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"os"
+	"strings"
 	"time"
 
 	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
 	"gitlab.com/bitspur/rock8s/patch-operator/config"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -93,3 +96,54 @@ func EnsureNamespacedName(
 		Namespace: Default(partialNamespacedName.Namespace, defaultNamespace),
 	}
 }
+
+// IsDryRun returns true if the patch item should only be dry run
+func IsDryRun(patch *patchv1alpha1.Patch, patchItem *patchv1alpha1.PatchSpecPatch) bool {
+	if patchItem.DryRun != nil {
+		return *patchItem.DryRun
+	}
+	return patch.Spec.DryRun
+}
+
+func TargetToResource(
+	patchId string,
+	patch *patchv1alpha1.Patch,
+	target *patchv1alpha1.Target,
+) (*unstructured.Unstructured, error) {
+	resource := unstructured.Unstructured{}
+	apiVersion := target.ApiVersion
+	if apiVersion == "" && target.Version != "" {
+		if target.Group != "" {
+			apiVersion = target.Group + "/"
+		}
+		apiVersion += target.Version
+	}
+	if apiVersion == "" {
+		return nil, errors.New(fmt.Sprintf("apiVersion missing in patch %s", patchId))
+	}
+	kind := target.Kind
+	name := target.Name
+	namespace := target.Namespace
+	if namespace == "" {
+		namespace = patch.GetNamespace()
+	}
+	resource.SetAPIVersion(apiVersion)
+	resource.SetKind(kind)
+	resource.SetName(name)
+	resource.SetNamespace(namespace)
+	return &resource, nil
+}
+
+// tail returns the last lines of a string
+func tail(s string, lines int) string {
+	split := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(split) > lines {
+		split = split[len(split)-lines:]
+	}
+	return strings.Join(split, "\n")
+}
+
+// lastLine returns the last line of a string that is not empty
+func lastLine(s string) string {
+	return tail(s, 1)
+}
@@ -23,6 +23,12 @@ package util
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
 	"gitlab.com/bitspur/rock8s/patch-operator/config"
@@ -31,55 +37,206 @@ import (
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const PatchLabel = config.PatchGroup + "." + config.Domain + "/patch"
 
+// RunLabel labels the job of a run with the number of the run
+const RunLabel = config.PatchGroup + "." + config.Domain + "/run"
+
+// LogsLabel labels the configmaps with the logs of the jobs of a patch by the
+// prefix of the jobs
+const LogsLabel = config.PatchGroup + "." + config.Domain + "/logs"
+
+// LogsTailLines is the number of lines of the logs kept in the status
+const LogsTailLines = 20
+
+// JobSecretDir is where the secret with the patch or script of a job is mounted
+const JobSecretDir = "/var/run/patch-operator"
+
+// ScriptKey is the key of the script in the secret mounted by script jobs
+const ScriptKey = "script.sh"
+
+// maxLogsBytes keeps the logs well within the 1MiB size limit of a configmap,
+// leaving room for its metadata
+const maxLogsBytes = 512 * 1024
+
 type JobUtil struct {
-	cfg       *rest.Config
-	clientset *kubernetes.Clientset
+	client    *client.Client
+	clientset kubernetes.Interface
 	ctx       *context.Context
+	name      string
+	owner     client.Object
+	prefix    string
 	patch     *patchv1alpha1.Patch
+	run       int32
 	scheme    *runtime.Scheme
 }
 
-func NewJobUtil(patch *patchv1alpha1.Patch, ctx *context.Context, scheme *runtime.Scheme) *JobUtil {
-	cfg := ctrl.GetConfigOrDie()
+// NewJobUtil creates a job util. jobs and configmaps are read through the
+// cached client, while pods and their logs are read through the clientset
+func NewJobUtil(
+	patch *patchv1alpha1.Patch,
+	client *client.Client,
+	clientset kubernetes.Interface,
+	ctx *context.Context,
+	scheme *runtime.Scheme,
+) *JobUtil {
 	return &JobUtil{
-		cfg:       cfg,
-		clientset: kubernetes.NewForConfigOrDie(cfg),
+		client:    client,
+		clientset: clientset,
 		ctx:       ctx,
+		name:      patch.GetName() + "-patch",
+		owner:     patch,
 		patch:     patch,
+		prefix:    patch.GetName() + "-patch",
 		scheme:    scheme,
 	}
 }
 
-func (j *JobUtil) Create(command string, env *[]v1.EnvVar) (*batchv1.Job, error) {
-	if command == "" {
-		command = "true"
+// SetOwner sets the resource that owns the job. jobs of cluster patches are
+// named differently so they do not collide with patches in the operator namespace
+func (j *JobUtil) SetOwner(owner client.Object) {
+	j.owner = owner
+	if _, ok := owner.(*patchv1alpha1.ClusterPatch); ok {
+		j.prefix = owner.GetName() + "-clusterpatch"
+		j.name = j.prefix
 	}
-	jobs := j.clientset.BatchV1().Jobs(j.patch.GetNamespace())
-	var backoffLimit int32 = 0
-	serviceAccountName := j.patch.Spec.ServiceAccountName
-	if serviceAccountName == "" {
-		serviceAccountName = "default"
+}
+
+// SetName sets the name of the job. the name defaults to the prefix of the
+// jobs, which is the name jobs had before every run got its own job
+func (j *JobUtil) SetName(name string) {
+	j.name = name
+}
+
+// SetRun names the job after a run of the patches
+func (j *JobUtil) SetRun(run int32) {
+	j.name = fmt.Sprintf("%s-%d", j.prefix, run)
+	j.run = run
+}
+
+func (j *JobUtil) Name() string {
+	return j.name
+}
+
+// Create creates a job that runs a script in the image of the patch. the
+// script is mounted from a secret owned by the job, so patches read from
+// secrets never show up in the job. the image is defaulted when the patch is
+// stored, so the default image only applies to patches stored without the
+// defaulting webhook
+func (j *JobUtil) Create(script string) (*batchv1.Job, error) {
+	if script == "" {
+		script = "true"
 	}
-	image := j.patch.Spec.Image
-	if image == "" {
-		image = "registry.gitlab.com/bitspur/rock8s/images/kube-commands:3.18.0"
+	return j.createWithSecret(v1.Container{
+		Image: Default(j.patch.Spec.Image, GetDefaultImage()),
+		Command: []string{
+			"/bin/sh",
+			filepath.Join(JobSecretDir, ScriptKey),
+		},
+		Args: []string{},
+		Env:  []v1.EnvVar{},
+	}, map[string][]byte{
+		ScriptKey: []byte(script),
+	})
+}
+
+// CreateRunner creates a job that runs the patch with the runner of the
+// operator image. the patch is mounted from a secret owned by the job
+func (j *JobUtil) CreateRunner(runnerPatch *patchv1alpha1.Patch) (*batchv1.Job, error) {
+	data, err := json.Marshal(runnerPatch)
+	if err != nil {
+		return nil, err
+	}
+	return j.createWithSecret(v1.Container{
+		Image: GetRunnerImage(),
+		Command: []string{
+			"/manager",
+			RunnerCommand,
+		},
+		Args: []string{},
+		Env:  []v1.EnvVar{},
+	}, map[string][]byte{
+		RunnerPatchKey: data,
+	})
+}
+
+// createWithSecret creates a job with the data mounted from a secret owned by
+// the job. if the job of the run already exists, because it was created before
+// the status could be updated, the secret is also created and the already
+// exists error is returned. any other job with the same name is an error
+func (j *JobUtil) createWithSecret(container v1.Container, data map[string][]byte) (*batchv1.Job, error) {
+	container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+		Name:      "patch",
+		MountPath: JobSecretDir,
+		ReadOnly:  true,
+	})
+	job, createErr := j.create(container, []v1.Volume{
+		{
+			Name: "patch",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: j.name,
+				},
+			},
+		},
+	})
+	if createErr != nil {
+		if !k8sErrors.IsAlreadyExists(createErr) {
+			return nil, createErr
+		}
+		var err error
+		if job, err = j.Get(); err != nil {
+			return nil, err
+		}
+		if !j.isRunJob(job) {
+			return nil, errors.New(fmt.Sprintf("job %s already exists", j.name))
+		}
 	}
-	labels := j.patch.Labels
-	if labels == nil {
-		labels = map[string]string{}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      j.name,
+			Namespace: j.patch.GetNamespace(),
+			Labels: map[string]string{
+				PatchLabel: j.patch.GetName(),
+			},
+		},
+		Data: data,
+	}
+	ctrl.SetControllerReference(job, secret, j.scheme)
+	if err := (*j.client).Create(*j.ctx, secret); err != nil && !k8sErrors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	return job, createErr
+}
+
+// create creates a job with the container, which is named kubectl so the job
+// template and the logs find it regardless of the command it runs. the default
+// service account only applies to patches stored without the defaulting webhook
+func (j *JobUtil) create(container v1.Container, volumes []v1.Volume) (*batchv1.Job, error) {
+	var backoffLimit int32 = 0
+	serviceAccountName := Default(j.patch.Spec.ServiceAccountName, GetDefaultServiceAccountName())
+	container.Name = "kubectl"
+	container.ImagePullPolicy = v1.PullIfNotPresent
+	container.TerminationMessagePolicy = v1.TerminationMessageFallbackToLogsOnError
+	labels := map[string]string{}
+	for key, value := range j.patch.Labels {
+		labels[key] = value
 	}
 	labels[PatchLabel] = j.patch.GetName()
+	if j.run > 0 {
+		labels[RunLabel] = fmt.Sprint(j.run)
+	}
 	automountServiceAccountToken := true
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      j.patch.GetName() + "-patch",
+			Name:      j.name,
 			Namespace: j.patch.GetNamespace(),
 			Labels:    labels,
 		},
@@ -97,32 +254,109 @@ func (j *JobUtil) Create(command string, env *[]v1.EnvVar) (*batchv1.Job, error)
 					AutomountServiceAccountToken: &automountServiceAccountToken,
 					RestartPolicy:                v1.RestartPolicyNever,
 					ServiceAccountName:           serviceAccountName,
-					Containers: []v1.Container{
-						{
-							Name:            "kubectl",
-							Image:           image,
-							ImagePullPolicy: v1.PullIfNotPresent,
-							Command: []string{
-								"/bin/sh",
-								"-c",
-								command,
-							},
-							Args: []string{},
-							Env:  *env,
-						},
-					},
+					Containers:                   []v1.Container{container},
+					Volumes:                      volumes,
 				},
 			},
 			BackoffLimit: &backoffLimit,
 		},
 	}
-	ctrl.SetControllerReference(j.patch, job, j.scheme)
-	return jobs.Create(*j.ctx, job, metav1.CreateOptions{})
+	job, err := j.applyJobTemplate(job)
+	if err != nil {
+		return nil, err
+	}
+	ctrl.SetControllerReference(j.owner, job, j.scheme)
+	if err := (*j.client).Create(*j.ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// applyJobTemplate merges the job template of the patch strategically into the
+// job, so lists such as env and volumes are merged by name
+func (j *JobUtil) applyJobTemplate(job *batchv1.Job) (*batchv1.Job, error) {
+	jobTemplate := j.patch.Spec.JobTemplate
+	if jobTemplate == nil {
+		return job, nil
+	}
+	container := v1.Container{
+		Name:            "kubectl",
+		Env:             jobTemplate.Env,
+		EnvFrom:         jobTemplate.EnvFrom,
+		VolumeMounts:    jobTemplate.VolumeMounts,
+		SecurityContext: jobTemplate.SecurityContext,
+	}
+	if jobTemplate.Resources != nil {
+		container.Resources = *jobTemplate.Resources
+	}
+	patch := &batchv1.Job{
+		Spec: batchv1.JobSpec{
+			ActiveDeadlineSeconds: jobTemplate.ActiveDeadlineSeconds,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: jobTemplate.Annotations,
+				},
+				Spec: v1.PodSpec{
+					Affinity:          jobTemplate.Affinity,
+					Containers:        []v1.Container{container},
+					ImagePullSecrets:  jobTemplate.ImagePullSecrets,
+					NodeSelector:      jobTemplate.NodeSelector,
+					PriorityClassName: jobTemplate.PriorityClassName,
+					SecurityContext:   jobTemplate.PodSecurityContext,
+					Tolerations:       jobTemplate.Tolerations,
+					Volumes:           jobTemplate.Volumes,
+				},
+			},
+		},
+	}
+	original, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	patchBody, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := strategicpatch.StrategicMergePatch(original, patchBody, batchv1.Job{})
+	if err != nil {
+		return nil, err
+	}
+	mergedJob := &batchv1.Job{}
+	if err := json.Unmarshal(merged, mergedJob); err != nil {
+		return nil, err
+	}
+	return mergedJob, nil
 }
 
+// Get reads the job from the cache. a job missing from the cache is read from
+// the api server, because the cache may not have seen a job that was just created
 func (j *JobUtil) Get() (*batchv1.Job, error) {
-	jobs := j.clientset.BatchV1().Jobs(j.patch.GetNamespace())
-	return jobs.Get(*j.ctx, j.patch.GetName()+"-patch", metav1.GetOptions{})
+	job := &batchv1.Job{}
+	if err := (*j.client).Get(*j.ctx, types.NamespacedName{
+		Name:      j.name,
+		Namespace: j.patch.GetNamespace(),
+	}, job); err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			return nil, err
+		}
+		jobs := j.clientset.BatchV1().Jobs(j.patch.GetNamespace())
+		return jobs.Get(*j.ctx, j.name, metav1.GetOptions{})
+	}
+	return job, nil
+}
+
+// isRunJob returns true if the job is the job of the run, which is owned by
+// the owner of the job util, labeled with the run and not being deleted
+func (j *JobUtil) isRunJob(job *batchv1.Job) bool {
+	if job.GetDeletionTimestamp() != nil || job.GetLabels()[RunLabel] != fmt.Sprint(j.run) {
+		return false
+	}
+	for _, ownerReference := range job.OwnerReferences {
+		if ownerReference.UID == j.owner.GetUID() {
+			return true
+		}
+	}
+	return false
 }
 
 func (j *JobUtil) Owned() (bool, error) {
@@ -134,13 +368,15 @@ func (j *JobUtil) Owned() (bool, error) {
 		return false, err
 	}
 	for _, ownerReference := range job.OwnerReferences {
-		if ownerReference.UID == j.patch.GetUID() {
+		if ownerReference.UID == j.owner.GetUID() {
 			return true, nil
 		}
 	}
 	return false, nil
 }
 
+// Delete deletes the job in the foreground, so the job is only gone once its
+// pods are gone
 func (j *JobUtil) Delete() error {
 	owned, err := j.Owned()
 	if err != nil {
@@ -149,8 +385,13 @@ func (j *JobUtil) Delete() error {
 	if !owned {
 		return nil
 	}
-	jobs := j.clientset.BatchV1().Jobs(j.patch.GetNamespace())
-	if err := jobs.Delete(*j.ctx, j.patch.Name, metav1.DeleteOptions{}); err != nil {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      j.name,
+			Namespace: j.patch.GetNamespace(),
+		},
+	}
+	if err := (*j.client).Delete(*j.ctx, job, client.PropagationPolicy(metav1.DeletePropagationForeground)); err != nil {
 		if k8sErrors.IsNotFound(err) {
 			return nil
 		}
@@ -159,6 +400,31 @@ func (j *JobUtil) Delete() error {
 	return nil
 }
 
+// Terminating returns the name of a job of the owner that is being deleted,
+// or an empty string if there is none
+func (j *JobUtil) Terminating() (string, error) {
+	jobList := &batchv1.JobList{}
+	if err := (*j.client).List(
+		*j.ctx,
+		jobList,
+		client.InNamespace(j.patch.GetNamespace()),
+		client.MatchingLabels{PatchLabel: j.patch.GetName()},
+	); err != nil {
+		return "", err
+	}
+	for _, job := range jobList.Items {
+		if job.GetDeletionTimestamp() == nil {
+			continue
+		}
+		for _, ownerReference := range job.OwnerReferences {
+			if ownerReference.UID == j.owner.GetUID() {
+				return job.GetName(), nil
+			}
+		}
+	}
+	return "", nil
+}
+
 func (j *JobUtil) Completed() (bool, string, error) {
 	job, err := j.Get()
 	if err != nil {
@@ -181,6 +447,141 @@ func (j *JobUtil) Completed() (bool, string, error) {
 	return true, "", nil
 }
 
+// ExitCode returns the exit code of the failed job container or nil if it is unknown
+func (j *JobUtil) ExitCode() (*int32, error) {
+	pods := j.clientset.CoreV1().Pods(j.patch.GetNamespace())
+	podList, err := pods.List(*j.ctx, metav1.ListOptions{
+		LabelSelector: "job-name=" + j.name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range podList.Items {
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			terminated := containerStatus.State.Terminated
+			if terminated != nil && terminated.ExitCode != 0 {
+				exitCode := terminated.ExitCode
+				return &exitCode, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Logs returns the termination message and the logs of the job container.
+// both are empty when the pod was cleaned up
+func (j *JobUtil) Logs() (string, string, error) {
+	pod, err := j.pod()
+	if err != nil || pod == nil {
+		return "", "", err
+	}
+	terminationMessage := ""
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.State.Terminated != nil {
+			terminationMessage = containerStatus.State.Terminated.Message
+		}
+	}
+	pods := j.clientset.CoreV1().Pods(j.patch.GetNamespace())
+	logs, err := pods.GetLogs(pod.GetName(), &v1.PodLogOptions{Container: "kubectl"}).DoRaw(*j.ctx)
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return terminationMessage, "", nil
+		}
+		return "", "", err
+	}
+	if len(logs) > maxLogsBytes {
+		logs = logs[len(logs)-maxLogsBytes:]
+	}
+	return terminationMessage, string(logs), nil
+}
+
+// SaveLogs stores the logs of the job in a configmap and deletes the oldest
+// configmaps beyond the history limit. it returns the name of the configmap
+func (j *JobUtil) SaveLogs(logs string, historyLimit int32) (string, error) {
+	job, err := j.Get()
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	name := ""
+	if historyLimit > 0 {
+		name = fmt.Sprintf("%s-logs-%s", j.name, strings.Split(string(job.GetUID()), "-")[0])
+		configMap := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: j.patch.GetNamespace(),
+				Labels: map[string]string{
+					PatchLabel: j.patch.GetName(),
+					LogsLabel:  j.prefix,
+				},
+			},
+			Data: map[string]string{
+				"logs": logs,
+			},
+		}
+		ctrl.SetControllerReference(j.owner, configMap, j.scheme)
+		configMaps := j.clientset.CoreV1().ConfigMaps(j.patch.GetNamespace())
+		if _, err := configMaps.Create(*j.ctx, configMap, metav1.CreateOptions{}); err != nil && !k8sErrors.IsAlreadyExists(err) {
+			return "", err
+		}
+	}
+	if err := j.pruneLogs(historyLimit); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// pruneLogs deletes the oldest configmaps with logs beyond the history limit.
+// the configmaps are listed from the api server, since the cache only has the
+// metadata of configmaps
+func (j *JobUtil) pruneLogs(historyLimit int32) error {
+	configMaps := j.clientset.CoreV1().ConfigMaps(j.patch.GetNamespace())
+	configMapList, err := configMaps.List(*j.ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", LogsLabel, j.prefix),
+	})
+	if err != nil {
+		return err
+	}
+	owned := []v1.ConfigMap{}
+	for _, configMap := range configMapList.Items {
+		for _, ownerReference := range configMap.OwnerReferences {
+			if ownerReference.UID == j.owner.GetUID() {
+				owned = append(owned, configMap)
+			}
+		}
+	}
+	sort.Slice(owned, func(a, b int) bool {
+		return owned[b].CreationTimestamp.Before(&owned[a].CreationTimestamp)
+	})
+	for i := int(historyLimit); i < len(owned); i++ {
+		if err := configMaps.Delete(*j.ctx, owned[i].GetName(), metav1.DeleteOptions{}); err != nil && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// pod returns the most recent pod of the job or nil if there is none
+func (j *JobUtil) pod() (*v1.Pod, error) {
+	pods := j.clientset.CoreV1().Pods(j.patch.GetNamespace())
+	podList, err := pods.List(*j.ctx, metav1.ListOptions{
+		LabelSelector: "job-name=" + j.name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var latest *v1.Pod
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if latest == nil || latest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			latest = pod
+		}
+	}
+	return latest, nil
+}
+
 func (j *JobUtil) findJobStatusCondition(conditions []batchv1.JobCondition, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
 	for i := range conditions {
 		if conditions[i].Type == conditionType {
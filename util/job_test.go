@@ -0,0 +1,398 @@
+/**
+ * File: /job_test.go
+ * Project: util
+ * File Created: 17-10-2026 06:39:24
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := patchv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func newTestJobUtil(t *testing.T, patch *patchv1alpha1.Patch, objects ...client.Object) (*JobUtil, client.Client) {
+	scheme := newTestScheme(t)
+	var c client.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	ctx := context.Background()
+	return NewJobUtil(patch, &c, kubefake.NewSimpleClientset(), &ctx, scheme), c
+}
+
+func TestCreateMountsSecret(t *testing.T) {
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "default", UID: "patch-uid"},
+	}
+	script := "cat <<EOF > /tmp/patch.json\n{\"password\":\"hunter2\"}\nEOF\n"
+	tests := []struct {
+		name   string
+		create func(jobUtil *JobUtil) error
+		key    string
+		data   string
+	}{
+		{
+			name: "script",
+			create: func(jobUtil *JobUtil) error {
+				_, err := jobUtil.Create(script)
+				return err
+			},
+			key:  ScriptKey,
+			data: script,
+		},
+		{
+			name: "runner",
+			create: func(jobUtil *JobUtil) error {
+				_, err := jobUtil.CreateRunner(&patchv1alpha1.Patch{
+					Spec: patchv1alpha1.PatchSpec{
+						Patches: []patchv1alpha1.PatchSpecPatch{{Patch: `{"password":"hunter2"}`}},
+					},
+				})
+				return err
+			},
+			key:  RunnerPatchKey,
+			data: "hunter2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobUtil, c := newTestJobUtil(t, patch)
+			jobUtil.SetRun(1)
+			if err := test.create(jobUtil); err != nil {
+				t.Fatal(err)
+			}
+			job, err := jobUtil.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			container := job.Spec.Template.Spec.Containers[0]
+			if strings.Contains(strings.Join(append(container.Command, container.Args...), " "), "hunter2") {
+				t.Fatalf("expected the patch to be kept out of the command, got %v", container.Command)
+			}
+			if len(container.VolumeMounts) != 1 || container.VolumeMounts[0].MountPath != JobSecretDir {
+				t.Fatalf("expected the secret to be mounted at %s, got %v", JobSecretDir, container.VolumeMounts)
+			}
+			secret := &v1.Secret{}
+			if err := c.Get(context.Background(), types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, secret); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(secret.Data[test.key]), test.data) {
+				t.Fatalf("expected key %s of the secret to contain %q, got %q", test.key, test.data, secret.Data[test.key])
+			}
+			owner := metav1.GetControllerOf(secret)
+			if owner == nil || owner.Kind != "Job" || owner.Name != job.Name {
+				t.Fatalf("expected the secret to be owned by job %s, got %v", job.Name, owner)
+			}
+		})
+	}
+}
+
+func TestSaveLogsPrunesOldest(t *testing.T) {
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "patch-uid"},
+	}
+	jobUtil, _ := newTestJobUtil(t, patch)
+	for run := int32(1); run <= 4; run++ {
+		jobUtil.SetRun(run)
+		if _, err := jobUtil.Create("true"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := jobUtil.SaveLogs(fmt.Sprintf("logs of run %d", run), 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	configMapList, err := jobUtil.clientset.CoreV1().ConfigMaps(patch.Namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configMapList.Items) != 2 {
+		t.Fatalf("expected 2 configmaps with logs, got %d", len(configMapList.Items))
+	}
+}
+
+func TestCreateWithExistingJob(t *testing.T) {
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "patch-uid"},
+	}
+	now := metav1.Now()
+	existingJob := func(run string, ownerUID types.UID, deleting bool) *batchv1.Job {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-patch-3",
+				Namespace: "default",
+				Labels:    map[string]string{PatchLabel: "web", RunLabel: run},
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "patch.rock8s.com/v1alpha1", Kind: "Patch", Name: "web", UID: ownerUID},
+				},
+			},
+		}
+		if deleting {
+			job.SetDeletionTimestamp(&now)
+			job.SetFinalizers([]string{"foregroundDeletion"})
+		}
+		return job
+	}
+	tests := []struct {
+		name string
+		job  *batchv1.Job
+		err  string
+	}{
+		{
+			name: "job of the run",
+			job:  existingJob("3", "patch-uid", false),
+		},
+		{
+			name: "job of an older run",
+			job:  existingJob("1", "patch-uid", false),
+			err:  "job web-patch-3 already exists",
+		},
+		{
+			name: "job of the run being deleted",
+			job:  existingJob("3", "patch-uid", true),
+			err:  "job web-patch-3 already exists",
+		},
+		{
+			name: "job of another owner",
+			job:  existingJob("3", "other-uid", false),
+			err:  "job web-patch-3 already exists",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobUtil, c := newTestJobUtil(t, patch, test.job)
+			jobUtil.SetRun(3)
+			_, err := jobUtil.Create("true")
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if !k8sErrors.IsAlreadyExists(err) {
+				t.Fatalf("expected the job of the run to already exist, got %v", err)
+			}
+			secret := &v1.Secret{}
+			if err := c.Get(context.Background(), types.NamespacedName{Name: "web-patch-3", Namespace: "default"}, secret); err != nil {
+				t.Fatalf("expected the secret of the job to be created, got %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestCreateLabelsRun(t *testing.T) {
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "default",
+			UID:       "patch-uid",
+			Labels:    map[string]string{"team": "payments"},
+		},
+	}
+	jobUtil, _ := newTestJobUtil(t, patch)
+	jobUtil.SetRun(7)
+	job, err := jobUtil.Create("true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Labels[RunLabel] != "7" || job.Labels["team"] != "payments" {
+		t.Fatalf("expected the job to be labeled with the run and the patch labels, got %v", job.Labels)
+	}
+	if _, ok := patch.Labels[RunLabel]; ok {
+		t.Fatal("expected the labels of the patch to be left unchanged")
+	}
+}
+
+func TestTerminating(t *testing.T) {
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "patch-uid"},
+	}
+	now := metav1.Now()
+	job := func(name string, ownerUID types.UID, deleting bool) *batchv1.Job {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "default",
+				Labels:          map[string]string{PatchLabel: "web"},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "Patch", Name: "web", UID: ownerUID}},
+			},
+		}
+		if deleting {
+			job.SetDeletionTimestamp(&now)
+			job.SetFinalizers([]string{"foregroundDeletion"})
+		}
+		return job
+	}
+	tests := []struct {
+		name        string
+		jobs        []client.Object
+		terminating string
+	}{
+		{
+			name: "no jobs",
+		},
+		{
+			name: "completed jobs",
+			jobs: []client.Object{job("web-patch-1", "patch-uid", false)},
+		},
+		{
+			name:        "job of a canceled run",
+			jobs:        []client.Object{job("web-patch-1", "patch-uid", false), job("web-patch-2", "patch-uid", true)},
+			terminating: "web-patch-2",
+		},
+		{
+			name:        "job named before every run got its own job",
+			jobs:        []client.Object{job("web-patch", "patch-uid", true)},
+			terminating: "web-patch",
+		},
+		{
+			name: "job of another owner",
+			jobs: []client.Object{job("web-clusterpatch-1", "other-uid", true)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobUtil, _ := newTestJobUtil(t, patch, test.jobs...)
+			terminating, err := jobUtil.Terminating()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if terminating != test.terminating {
+				t.Fatalf("expected terminating job %q, got %q", test.terminating, terminating)
+			}
+		})
+	}
+}
+
+func TestApplyJobTemplate(t *testing.T) {
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "patch-uid"},
+		Spec: patchv1alpha1.PatchSpec{
+			JobTemplate: &patchv1alpha1.PatchSpecJobTemplate{
+				Annotations:  map[string]string{"example.com/team": "payments"},
+				NodeSelector: map[string]string{"kubernetes.io/os": "linux"},
+				Env: []v1.EnvVar{
+					{Name: "HTTPS_PROXY", Value: "http://proxy:3128"},
+					{Name: "LOG_LEVEL", Value: "debug"},
+				},
+				Volumes: []v1.Volume{{
+					Name:         "cache",
+					VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+				}},
+				VolumeMounts: []v1.VolumeMount{{Name: "cache", MountPath: "/cache"}},
+			},
+		},
+	}
+	controller := true
+	ownerReferences := []metav1.OwnerReference{{
+		APIVersion: patchv1alpha1.GroupVersion.String(),
+		Kind:       "Patch",
+		Name:       "web",
+		UID:        "patch-uid",
+		Controller: &controller,
+	}}
+	command := []string{"/bin/sh", "/patch/script.sh"}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", OwnerReferences: ownerReferences},
+		Spec: batchv1.JobSpec{
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{"sidecar.istio.io/inject": "false"},
+				},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{
+						Name:         "kubectl",
+						Command:      command,
+						Env:          []v1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}},
+						VolumeMounts: []v1.VolumeMount{{Name: "patch", MountPath: JobSecretDir, ReadOnly: true}},
+					}},
+					Volumes: []v1.Volume{{
+						Name:         "patch",
+						VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "web-1"}},
+					}},
+				},
+			},
+		},
+	}
+	jobUtil, _ := newTestJobUtil(t, patch)
+	merged, err := jobUtil.applyJobTemplate(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podSpec := merged.Spec.Template.Spec
+	if len(podSpec.Containers) != 1 || podSpec.Containers[0].Name != "kubectl" {
+		t.Fatalf("expected the template to merge into the kubectl container, got %v", podSpec.Containers)
+	}
+	container := podSpec.Containers[0]
+	if strings.Join(container.Command, " ") != strings.Join(command, " ") {
+		t.Fatalf("expected command %v to be kept, got %v", command, container.Command)
+	}
+	env := map[string]string{}
+	for _, envVar := range container.Env {
+		env[envVar.Name] = envVar.Value
+	}
+	if len(env) != 2 || env["HTTPS_PROXY"] != "http://proxy:3128" || env["LOG_LEVEL"] != "debug" {
+		t.Fatalf("expected the env to be merged by name, got %v", container.Env)
+	}
+	mounts := map[string]string{}
+	for _, volumeMount := range container.VolumeMounts {
+		mounts[volumeMount.MountPath] = volumeMount.Name
+	}
+	if len(mounts) != 2 || mounts[JobSecretDir] != "patch" || mounts["/cache"] != "cache" {
+		t.Fatalf("expected the volume mounts to be merged, got %v", container.VolumeMounts)
+	}
+	volumes := map[string]v1.VolumeSource{}
+	for _, volume := range podSpec.Volumes {
+		volumes[volume.Name] = volume.VolumeSource
+	}
+	if len(volumes) != 2 || volumes["patch"].Secret == nil || volumes["cache"].EmptyDir == nil {
+		t.Fatalf("expected the volumes to be merged, got %v", podSpec.Volumes)
+	}
+	annotations := merged.Spec.Template.Annotations
+	if annotations["sidecar.istio.io/inject"] != "false" || annotations["example.com/team"] != "payments" {
+		t.Fatalf("expected the annotations to be merged, got %v", annotations)
+	}
+	if podSpec.NodeSelector["kubernetes.io/os"] != "linux" {
+		t.Fatalf("expected the node selector of the template, got %v", podSpec.NodeSelector)
+	}
+	if len(merged.OwnerReferences) != 1 || merged.OwnerReferences[0].UID != "patch-uid" {
+		t.Fatalf("expected owner references %v to be kept, got %v", ownerReferences, merged.OwnerReferences)
+	}
+}
@@ -27,37 +27,120 @@ package util
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
 
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	memory "k8s.io/client-go/discovery/cached"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
-	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
 )
 
 type PatchType string
 
 const (
+	ApplyPatchType     PatchType = "apply"
 	JsonPatchType      PatchType = "json"
 	MergePatchType     PatchType = "merge"
 	StrategicPatchType PatchType = "strategic"
 )
 
+const DefaultFieldManager = "patch-operator"
+
+// restMapperResetInterval is the minimum interval between resets of the rest
+// mapper, so targets of a kind that does not exist do not rediscover the api
+// on every reconcile
+const restMapperResetInterval = time.Duration(time.Second * 10)
+
+// ResettableRESTMapper is a rest mapper that can be reset to rediscover the api
+type ResettableRESTMapper interface {
+	meta.RESTMapper
+	Reset()
+}
+
 type KubectlUtil struct {
-	ctx *context.Context
-	cfg *rest.Config
+	cfg          *rest.Config
+	ctx          *context.Context
+	dynamic      dynamic.Interface
+	fieldManager string
+	force        bool
+	mapper       ResettableRESTMapper
+	shared       *kubectlShared
+	username     string
+}
+
+// kubectlShared is the state shared by the copies of a kubectl util
+type kubectlShared struct {
+	impersonated map[string]dynamic.Interface
+	lastReset    time.Time
+	metadata     map[string]metadata.Interface
+	mutex        sync.Mutex
+	readers      map[string]client.Reader
 }
 
-func NewKubectlUtil(ctx *context.Context) *KubectlUtil {
+// NewKubectlUtil creates a kubectl util with a shared rest mapper and dynamic
+// client. it is created once by the manager and copied for every use
+func NewKubectlUtil(cfg *rest.Config, mapper ResettableRESTMapper, dyn dynamic.Interface) *KubectlUtil {
+	ctx := context.Background()
 	return &KubectlUtil{
-		cfg: ctrl.GetConfigOrDie(),
-		ctx: ctx,
+		cfg:          cfg,
+		ctx:          &ctx,
+		dynamic:      dyn,
+		fieldManager: DefaultFieldManager,
+		mapper:       mapper,
+		shared: &kubectlShared{
+			impersonated: map[string]dynamic.Interface{},
+			metadata:     map[string]metadata.Interface{},
+			readers:      map[string]client.Reader{},
+		},
+	}
+}
+
+// NewKubectlUtilForConfig creates a kubectl util with a rest mapper that caches
+// the discovery of the cluster
+func NewKubectlUtilForConfig(cfg *rest.Config) (*KubectlUtil, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, err
 	}
+	dyn, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewKubectlUtil(cfg, restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc)), dyn), nil
+}
+
+// WithContext returns a copy of the kubectl util for the context
+func (u *KubectlUtil) WithContext(ctx *context.Context) *KubectlUtil {
+	kubectlUtil := *u
+	kubectlUtil.ctx = ctx
+	return &kubectlUtil
+}
+
+// ForServiceAccount returns a copy of the kubectl util that impersonates the
+// service account
+func (u *KubectlUtil) ForServiceAccount(namespace string, serviceAccountName string) *KubectlUtil {
+	kubectlUtil := *u
+	kubectlUtil.username = fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccountName)
+	return &kubectlUtil
+}
+
+// SetFieldManager sets the field manager and whether server side apply patches
+// take ownership of conflicting fields
+func (u *KubectlUtil) SetFieldManager(fieldManager string, force bool) {
+	u.fieldManager = Default(fieldManager, DefaultFieldManager)
+	u.force = force
 }
 
 func (u *KubectlUtil) Create(resource []byte) error {
@@ -66,7 +149,7 @@ func (u *KubectlUtil) Create(resource []byte) error {
 		return err
 	}
 	if _, err := dr.Create(*u.ctx, obj, metav1.CreateOptions{
-		FieldManager: "integration-operator",
+		FieldManager: u.fieldManager,
 	}); err != nil {
 		return err
 	}
@@ -79,7 +162,7 @@ func (u *KubectlUtil) Update(resource []byte) error {
 		return err
 	}
 	if _, err := dr.Update(*u.ctx, obj, metav1.UpdateOptions{
-		FieldManager: "integration-operator",
+		FieldManager: u.fieldManager,
 	}); err != nil {
 		return err
 	}
@@ -96,34 +179,72 @@ func (u *KubectlUtil) Apply(resource []byte) error {
 		return err
 	}
 	if _, err = dr.Patch(*u.ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
-		FieldManager: "integration-operator",
+		FieldManager: u.fieldManager,
 	}); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (u *KubectlUtil) Patch(resource []byte, patchType PatchType, patch []byte) error {
+func (u *KubectlUtil) Patch(resource []byte, patchType PatchType, patch []byte) (*unstructured.Unstructured, error) {
+	return u.patch(resource, patchType, patch, nil)
+}
+
+// PatchDryRun returns the resource as it would be after the patch without persisting it
+func (u *KubectlUtil) PatchDryRun(resource []byte, patchType PatchType, patch []byte) (*unstructured.Unstructured, error) {
+	return u.patch(resource, patchType, patch, []string{metav1.DryRunAll})
+}
+
+func (u *KubectlUtil) patch(
+	resource []byte,
+	patchType PatchType,
+	patch []byte,
+	dryRun []string,
+) (*unstructured.Unstructured, error) {
 	dr, obj, err := u.prepareDynamic(resource)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	data, err := json.Marshal(obj)
+	data, err := yaml.YAMLToJSON(patch)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	var force *bool
 	pt := types.StrategicMergePatchType
 	if patchType == JsonPatchType {
 		pt = types.JSONPatchType
 	} else if patchType == MergePatchType {
 		pt = types.MergePatchType
+	} else if patchType == ApplyPatchType {
+		pt = types.ApplyPatchType
+		force = &u.force
+		data, err = ApplyPatchBody(obj, data)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if _, err = dr.Patch(*u.ctx, obj.GetName(), pt, data, metav1.PatchOptions{
-		FieldManager: "integration-operator",
-	}); err != nil {
-		return err
+	return dr.Patch(*u.ctx, obj.GetName(), pt, data, metav1.PatchOptions{
+		DryRun:       dryRun,
+		FieldManager: u.fieldManager,
+		Force:        force,
+	})
+}
+
+// ApplyPatchBody completes a server side apply patch with the apiVersion, kind,
+// name and namespace of the resource it is applied to
+func ApplyPatchBody(resource *unstructured.Unstructured, patch []byte) ([]byte, error) {
+	body := map[string]interface{}{}
+	if err := yaml.Unmarshal(patch, &body); err != nil {
+		return nil, err
 	}
-	return nil
+	obj := unstructured.Unstructured{Object: body}
+	obj.SetAPIVersion(resource.GetAPIVersion())
+	obj.SetKind(resource.GetKind())
+	obj.SetName(resource.GetName())
+	if resource.GetNamespace() != "" {
+		obj.SetNamespace(resource.GetNamespace())
+	}
+	return json.Marshal(obj.Object)
 }
 
 func (u *KubectlUtil) Delete(resource []byte) error {
@@ -145,35 +266,40 @@ func (u *KubectlUtil) Get(resource []byte) (*unstructured.Unstructured, error) {
 	return dr.Get(*u.ctx, obj.GetName(), metav1.GetOptions{})
 }
 
-// https://ymmt2005.hatenablog.com/entry/2020/04/14/An_example_of_using_dynamic_client_of_k8s.io/client-go
-func (u *KubectlUtil) prepareDynamic(resource []byte) (dynamic.ResourceInterface, *unstructured.Unstructured, error) {
-	// 1. Prepare a RESTMapper to find GVR
-	dc, err := discovery.NewDiscoveryClientForConfig(u.cfg)
+// List lists the resources of the kind. resources in all namespaces are listed
+// if the resource has no namespace
+func (u *KubectlUtil) List(resource []byte, labelSelector string) (*unstructured.UnstructuredList, error) {
+	dr, _, err := u.prepareDynamic(resource)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc))
+	return dr.List(*u.ctx, metav1.ListOptions{
+		LabelSelector: labelSelector,
+	})
+}
 
-	// 2. Prepare the dynamic client
-	dyn, err := dynamic.NewForConfig(u.cfg)
+// https://ymmt2005.hatenablog.com/entry/2020/04/14/An_example_of_using_dynamic_client_of_k8s.io/client-go
+func (u *KubectlUtil) prepareDynamic(resource []byte) (dynamic.ResourceInterface, *unstructured.Unstructured, error) {
+	// 1. Get the dynamic client
+	dyn, err := u.dynamicClient()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// 3. Decode YAML manifest into unstructured.Unstructured
+	// 2. Decode YAML manifest into unstructured.Unstructured
 	obj := &unstructured.Unstructured{}
 	_, gvk, err := decUnstructured.Decode(resource, nil, obj)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// 4. Find GVR
-	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	// 3. Find GVR, rediscovering the api if the kind is unknown
+	mapping, err := u.RESTMapping(*gvk)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// 5. Obtain REST interface for the GVR
+	// 4. Obtain REST interface for the GVR
 	var dr dynamic.ResourceInterface
 	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
 		// namespaced resources should specify the namespace
@@ -185,3 +311,114 @@ func (u *KubectlUtil) prepareDynamic(resource []byte) (dynamic.ResourceInterface
 
 	return dr, obj, nil
 }
+
+// TargetToResource returns the resource of a target with the namespace
+// cleared for cluster scoped kinds. the namespace is kept for kinds that are
+// not installed, since their scope is unknown
+func (u *KubectlUtil) TargetToResource(
+	patchId string,
+	patch *patchv1alpha1.Patch,
+	target *patchv1alpha1.Target,
+) (*unstructured.Unstructured, error) {
+	resource, err := TargetToResource(patchId, patch, target)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := u.RESTMapping(resource.GroupVersionKind())
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return resource, nil
+		}
+		return nil, err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		resource.SetNamespace("")
+	}
+	return resource, nil
+}
+
+// RESTMapping finds the mapping of a kind, rediscovering the api if the kind
+// is unknown
+func (u *KubectlUtil) RESTMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := u.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) && u.resetMapper() {
+		mapping, err = u.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	return mapping, err
+}
+
+// dynamicClient returns the dynamic client, impersonating the service account
+// if the kubectl util is for a service account
+func (u *KubectlUtil) dynamicClient() (dynamic.Interface, error) {
+	if u.username == "" {
+		return u.dynamic, nil
+	}
+	u.shared.mutex.Lock()
+	defer u.shared.mutex.Unlock()
+	if dyn, ok := u.shared.impersonated[u.username]; ok {
+		return dyn, nil
+	}
+	dyn, err := dynamic.NewForConfig(u.restConfig())
+	if err != nil {
+		return nil, err
+	}
+	u.shared.impersonated[u.username] = dyn
+	return dyn, nil
+}
+
+// MetadataClient returns a client for the metadata of resources, impersonating
+// the service account if the kubectl util is for a service account
+func (u *KubectlUtil) MetadataClient() (metadata.Interface, error) {
+	u.shared.mutex.Lock()
+	defer u.shared.mutex.Unlock()
+	if metadataClient, ok := u.shared.metadata[u.username]; ok {
+		return metadataClient, nil
+	}
+	metadataClient, err := metadata.NewForConfig(u.restConfig())
+	if err != nil {
+		return nil, err
+	}
+	u.shared.metadata[u.username] = metadataClient
+	return metadataClient, nil
+}
+
+// Reader returns an uncached reader, impersonating the service account if the
+// kubectl util is for a service account
+func (u *KubectlUtil) Reader() (client.Reader, error) {
+	u.shared.mutex.Lock()
+	defer u.shared.mutex.Unlock()
+	if reader, ok := u.shared.readers[u.username]; ok {
+		return reader, nil
+	}
+	reader, err := client.New(u.restConfig(), client.Options{Mapper: u.mapper})
+	if err != nil {
+		return nil, err
+	}
+	u.shared.readers[u.username] = reader
+	return reader, nil
+}
+
+// restConfig returns the rest config, impersonating the service account if the
+// kubectl util is for a service account
+func (u *KubectlUtil) restConfig() *rest.Config {
+	cfg := rest.CopyConfig(u.cfg)
+	if u.username != "" {
+		cfg.Impersonate = rest.ImpersonationConfig{
+			UserName: u.username,
+		}
+	}
+	return cfg
+}
+
+// resetMapper resets the rest mapper unless it was reset recently and returns
+// whether it was reset
+func (u *KubectlUtil) resetMapper() bool {
+	u.shared.mutex.Lock()
+	defer u.shared.mutex.Unlock()
+	if time.Since(u.shared.lastReset) < restMapperResetInterval {
+		return false
+	}
+	u.mapper.Reset()
+	u.shared.lastReset = time.Now()
+	return true
+}
@@ -0,0 +1,124 @@
+/**
+ * File: /kubectl_test.go
+ * Project: util
+ * File Created: 17-10-2026 06:37:13
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"testing"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+// staticRESTMapper is a rest mapper with fixed kinds that counts its resets
+type staticRESTMapper struct {
+	*meta.DefaultRESTMapper
+	resets int
+}
+
+func (m *staticRESTMapper) Reset() {
+	m.resets++
+}
+
+func newStaticRESTMapper() *staticRESTMapper {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{})
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}, meta.RESTScopeRoot)
+	return &staticRESTMapper{DefaultRESTMapper: mapper}
+}
+
+func TestKubectlTargetToResource(t *testing.T) {
+	patch := &patchv1alpha1.Patch{ObjectMeta: metav1.ObjectMeta{Name: "patch", Namespace: "team"}}
+	tests := []struct {
+		name      string
+		target    patchv1alpha1.Target
+		namespace string
+		invalid   bool
+	}{
+		{
+			name:      "namespaced kind",
+			target:    patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+			namespace: "team",
+		},
+		{
+			name:      "namespaced kind in other namespace",
+			target:    patchv1alpha1.Target{Group: "apps", Version: "v1", Kind: "Deployment", Name: "web", Namespace: "other"},
+			namespace: "other",
+		},
+		{
+			name:   "cluster scoped kind",
+			target: patchv1alpha1.Target{ApiVersion: "v1", Kind: "Namespace", Name: "team"},
+		},
+		{
+			name:   "cluster scoped kind with namespace",
+			target: patchv1alpha1.Target{ApiVersion: "apiextensions.k8s.io/v1", Kind: "CustomResourceDefinition", Name: "widgets.example.com", Namespace: "team"},
+		},
+		{
+			name:      "kind not installed",
+			target:    patchv1alpha1.Target{ApiVersion: "example.com/v1", Kind: "Widget", Name: "web"},
+			namespace: "team",
+		},
+		{
+			name:    "api version missing",
+			target:  patchv1alpha1.Target{Kind: "Deployment", Name: "web"},
+			invalid: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubectlUtil := NewKubectlUtil(&rest.Config{}, newStaticRESTMapper(), nil)
+			resource, err := kubectlUtil.TargetToResource("0", patch, &test.target)
+			if test.invalid {
+				if err == nil {
+					t.Fatal("expected target to be invalid")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resource.GetNamespace() != test.namespace {
+				t.Fatalf("expected namespace %q, got %q", test.namespace, resource.GetNamespace())
+			}
+			if resource.GetName() != test.target.Name {
+				t.Fatalf("expected name %s, got %s", test.target.Name, resource.GetName())
+			}
+		})
+	}
+}
+
+func TestKubectlRESTMappingReset(t *testing.T) {
+	mapper := newStaticRESTMapper()
+	kubectlUtil := NewKubectlUtil(&rest.Config{}, mapper, nil)
+	gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+	for i := 0; i < 3; i++ {
+		if _, err := kubectlUtil.RESTMapping(gvk); !meta.IsNoMatchError(err) {
+			t.Fatalf("expected no match error, got %v", err)
+		}
+	}
+	if mapper.resets != 1 {
+		t.Fatalf("expected the mapper to be reset once within the reset interval, got %d resets", mapper.resets)
+	}
+}
@@ -0,0 +1,202 @@
+/**
+ * File: /metrics.go
+ * Project: util
+ * File Created: 17-10-2026 18:11:42
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var metricLabels = []string{"kind", "namespace", "name"}
+
+var patchApplications = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "patch_operator_patch_applications_total",
+		Help: "Number of times patches were applied to targets by result",
+	},
+	append(metricLabels, "result"),
+)
+
+var patchDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "patch_operator_patch_duration_seconds",
+		Help:    "Duration of patch executions in seconds",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+	},
+	metricLabels,
+)
+
+var patchRetries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "patch_operator_patch_retries_total",
+		Help: "Number of times failed patches were retried",
+	},
+	metricLabels,
+)
+
+var patchDriftCorrections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "patch_operator_patch_drift_corrections_total",
+		Help: "Number of times drifted targets were patched again",
+	},
+	metricLabels,
+)
+
+var metricPhases = []patchv1alpha1.Phase{
+	patchv1alpha1.FailedPhase,
+	patchv1alpha1.PendingPhase,
+	patchv1alpha1.SucceededPhase,
+	patchv1alpha1.UnknownPhase,
+	patchv1alpha1.WaitingPhase,
+}
+
+var metricResults = map[patchv1alpha1.PatchState]string{
+	patchv1alpha1.AppliedPatchState: "applied",
+	patchv1alpha1.SkippedPatchState: "skipped",
+	patchv1alpha1.FailedPatchState:  "failed",
+}
+
+func init() {
+	metrics.Registry.MustRegister(
+		patchApplications,
+		patchDuration,
+		patchRetries,
+		patchDriftCorrections,
+	)
+}
+
+// PatchCollector collects the metrics read from the status of the patches
+type PatchCollector struct {
+	reader           client.Reader
+	patches          *prometheus.Desc
+	sinceLastSuccess *prometheus.Desc
+}
+
+func NewPatchCollector(reader client.Reader) *PatchCollector {
+	return &PatchCollector{
+		reader: reader,
+		patches: prometheus.NewDesc(
+			"patch_operator_patches",
+			"Number of patches by phase",
+			[]string{"kind", "phase"},
+			nil,
+		),
+		sinceLastSuccess: prometheus.NewDesc(
+			"patch_operator_patch_seconds_since_last_success",
+			"Seconds since the patches last succeeded",
+			metricLabels,
+			nil,
+		),
+	}
+}
+
+// RegisterPatchCollector registers a patch collector in the controller-runtime
+// metrics registry
+func RegisterPatchCollector(reader client.Reader) error {
+	return metrics.Registry.Register(NewPatchCollector(reader))
+}
+
+func (c *PatchCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.patches
+	ch <- c.sinceLastSuccess
+}
+
+func (c *PatchCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	patchList := &patchv1alpha1.PatchList{}
+	if err := c.reader.List(ctx, patchList); err == nil {
+		c.collect(ch, "Patch", patchList.Items)
+	}
+	clusterPatchList := &patchv1alpha1.ClusterPatchList{}
+	if err := c.reader.List(ctx, clusterPatchList); err == nil {
+		patches := []patchv1alpha1.Patch{}
+		for _, clusterPatch := range clusterPatchList.Items {
+			patch := ClusterPatchToPatch(&clusterPatch)
+			patch.SetNamespace("")
+			patches = append(patches, *patch)
+		}
+		c.collect(ch, "ClusterPatch", patches)
+	}
+}
+
+func (c *PatchCollector) collect(ch chan<- prometheus.Metric, kind string, patches []patchv1alpha1.Patch) {
+	phases := map[patchv1alpha1.Phase]int{}
+	for _, phase := range metricPhases {
+		phases[phase] = 0
+	}
+	for _, patch := range patches {
+		phase := patch.Status.Phase
+		if phase == "" {
+			phase = patchv1alpha1.PendingPhase
+		}
+		phases[phase]++
+		if patch.Status.LastSuccessTime != nil {
+			ch <- prometheus.MustNewConstMetric(
+				c.sinceLastSuccess,
+				prometheus.GaugeValue,
+				time.Since(patch.Status.LastSuccessTime.Time).Seconds(),
+				kind,
+				patch.GetNamespace(),
+				patch.GetName(),
+			)
+		}
+	}
+	for phase, count := range phases {
+		ch <- prometheus.MustNewConstMetric(c.patches, prometheus.GaugeValue, float64(count), kind, string(phase))
+	}
+}
+
+// recordApplication counts the application of a patch to a target
+func (u *PatchUtil) recordApplication(state patchv1alpha1.PatchState) {
+	if result, ok := metricResults[state]; ok {
+		patchApplications.WithLabelValues(append(u.metricLabelValues(), result)...).Inc()
+	}
+}
+
+func (u *PatchUtil) observeDuration(duration time.Duration) {
+	patchDuration.WithLabelValues(u.metricLabelValues()...).Observe(duration.Seconds())
+}
+
+// DeleteMetrics removes the label series of a deleted patch
+func (u *PatchUtil) DeleteMetrics() {
+	labelValues := u.metricLabelValues()
+	for _, result := range metricResults {
+		patchApplications.DeleteLabelValues(append(labelValues, result)...)
+	}
+	patchDuration.DeleteLabelValues(labelValues...)
+	patchRetries.DeleteLabelValues(labelValues...)
+	patchDriftCorrections.DeleteLabelValues(labelValues...)
+}
+
+// metricLabelValues returns the kind, namespace and name of the patch. cluster
+// patches have no namespace
+func (u *PatchUtil) metricLabelValues() []string {
+	if u.clusterScoped {
+		return []string{"ClusterPatch", "", u.namespacedName.Name}
+	}
+	return []string{"Patch", u.namespacedName.Namespace, u.namespacedName.Name}
+}
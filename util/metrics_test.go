@@ -0,0 +1,148 @@
+/**
+ * File: /metrics_test.go
+ * Project: util
+ * File Created: 17-10-2026 07:18:38
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestPatchCollector(t *testing.T) {
+	lastSuccessTime := metav1.NewTime(time.Now().Add(-time.Minute))
+	patch := func(name string, phase patchv1alpha1.Phase) *patchv1alpha1.Patch {
+		return &patchv1alpha1.Patch{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status:     patchv1alpha1.PatchStatus{Phase: phase},
+		}
+	}
+	succeeded := patch("web", patchv1alpha1.SucceededPhase)
+	succeeded.Status.LastSuccessTime = &lastSuccessTime
+	clusterPatch := &patchv1alpha1.ClusterPatch{
+		ObjectMeta: metav1.ObjectMeta{Name: "crds"},
+		Status:     patchv1alpha1.PatchStatus{Phase: patchv1alpha1.WaitingPhase},
+	}
+	reader := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(
+		succeeded,
+		patch("api", patchv1alpha1.SucceededPhase),
+		patch("worker", patchv1alpha1.FailedPhase),
+		patch("new", ""),
+		clusterPatch,
+	).Build()
+	collector := NewPatchCollector(reader)
+	expected := `
+# HELP patch_operator_patches Number of patches by phase
+# TYPE patch_operator_patches gauge
+patch_operator_patches{kind="ClusterPatch",phase="Failed"} 0
+patch_operator_patches{kind="ClusterPatch",phase="Pending"} 0
+patch_operator_patches{kind="ClusterPatch",phase="Succeeded"} 0
+patch_operator_patches{kind="ClusterPatch",phase="Unknown"} 0
+patch_operator_patches{kind="ClusterPatch",phase="Waiting"} 1
+patch_operator_patches{kind="Patch",phase="Failed"} 1
+patch_operator_patches{kind="Patch",phase="Pending"} 1
+patch_operator_patches{kind="Patch",phase="Succeeded"} 2
+patch_operator_patches{kind="Patch",phase="Unknown"} 0
+patch_operator_patches{kind="Patch",phase="Waiting"} 0
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "patch_operator_patches"); err != nil {
+		t.Fatal(err)
+	}
+	if count := testutil.CollectAndCount(collector, "patch_operator_patch_seconds_since_last_success"); count != 1 {
+		t.Fatalf("expected the time since the last success of 1 patch, got %d", count)
+	}
+}
+
+func TestDeleteMetrics(t *testing.T) {
+	patchApplications.Reset()
+	patchDuration.Reset()
+	patchRetries.Reset()
+	patchDriftCorrections.Reset()
+	defer func() {
+		patchApplications.Reset()
+		patchDuration.Reset()
+		patchRetries.Reset()
+		patchDriftCorrections.Reset()
+	}()
+	var c client.Client = fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	ctx := context.Background()
+	web := newTestPatchUtil(t, &c, "web")
+	clusterPatchUtil := NewClusterPatchUtil(
+		&c,
+		kubefake.NewSimpleClientset(),
+		NewKubectlUtil(&rest.Config{}, newStaticRESTMapper(), nil),
+		&ctx,
+		&ctrl.Request{NamespacedName: types.NamespacedName{Name: "crds"}},
+		newTestScheme(t),
+		log.Log,
+		"crds",
+		record.NewFakeRecorder(10),
+	)
+	for _, patchUtil := range []*PatchUtil{web, clusterPatchUtil} {
+		patchUtil.recordApplication(patchv1alpha1.AppliedPatchState)
+		patchUtil.recordApplication(patchv1alpha1.FailedPatchState)
+		patchUtil.observeDuration(time.Second)
+		patchRetries.WithLabelValues(patchUtil.metricLabelValues()...).Inc()
+		patchDriftCorrections.WithLabelValues(patchUtil.metricLabelValues()...).Inc()
+	}
+	web.DeleteMetrics()
+	expected := `
+# HELP patch_operator_patch_applications_total Number of times patches were applied to targets by result
+# TYPE patch_operator_patch_applications_total counter
+patch_operator_patch_applications_total{kind="ClusterPatch",name="crds",namespace="",result="applied"} 1
+patch_operator_patch_applications_total{kind="ClusterPatch",name="crds",namespace="",result="failed"} 1
+`
+	if err := testutil.CollectAndCompare(patchApplications, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+	expected = `
+# HELP patch_operator_patch_retries_total Number of times failed patches were retried
+# TYPE patch_operator_patch_retries_total counter
+patch_operator_patch_retries_total{kind="ClusterPatch",name="crds",namespace=""} 1
+`
+	if err := testutil.CollectAndCompare(patchRetries, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+	expected = `
+# HELP patch_operator_patch_drift_corrections_total Number of times drifted targets were patched again
+# TYPE patch_operator_patch_drift_corrections_total counter
+patch_operator_patch_drift_corrections_total{kind="ClusterPatch",name="crds",namespace=""} 1
+`
+	if err := testutil.CollectAndCompare(patchDriftCorrections, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+	if count := testutil.CollectAndCount(patchDuration); count != 1 {
+		t.Fatalf("expected the duration of 1 patch, got %d", count)
+	}
+}
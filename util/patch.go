@@ -26,20 +26,25 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/cespare/xxhash"
-	"gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	jsonpatch "github.com/evanphx/json-patch"
 	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
 	"gitlab.com/bitspur/rock8s/patch-operator/config"
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -48,47 +53,69 @@ import (
 
 type PatchUtil struct {
 	client         *client.Client
+	clientset      kubernetes.Interface
 	ctx            *context.Context
 	kubectlUtil    *KubectlUtil
 	log            *log.DelegatingLogger
-	mutex          *sync.Mutex
 	namespacedName types.NamespacedName
+	original       *patchv1alpha1.Patch
+	recorder       record.EventRecorder
 	req            *ctrl.Request
 	scheme         *runtime.Scheme
+	clusterScoped  bool
 }
 
 func NewPatchUtil(
 	client *client.Client,
+	clientset kubernetes.Interface,
+	kubectlUtil *KubectlUtil,
 	ctx *context.Context,
 	req *ctrl.Request,
 	scheme *runtime.Scheme,
 	log *log.DelegatingLogger,
 	namespacedName *patchv1alpha1.NamespacedName,
-	mutex *sync.Mutex,
+	recorder record.EventRecorder,
 ) *PatchUtil {
 	operatorNamespace := GetOperatorNamespace()
-	if mutex == nil {
-		mutex = &sync.Mutex{}
-	}
 	return &PatchUtil{
 		client:         client,
+		clientset:      clientset,
 		ctx:            ctx,
-		kubectlUtil:    NewKubectlUtil(ctx),
+		kubectlUtil:    kubectlUtil.WithContext(ctx),
 		log:            log,
-		mutex:          mutex,
 		namespacedName: EnsureNamespacedName(namespacedName, operatorNamespace),
+		recorder:       recorder,
 		req:            req,
 		scheme:         scheme,
 	}
 }
 
+// NewClusterPatchUtil creates a patch util for a cluster patch. the cluster
+// patch is handled as a patch in the operator namespace
+func NewClusterPatchUtil(
+	client *client.Client,
+	clientset kubernetes.Interface,
+	kubectlUtil *KubectlUtil,
+	ctx *context.Context,
+	req *ctrl.Request,
+	scheme *runtime.Scheme,
+	log *log.DelegatingLogger,
+	name string,
+	recorder record.EventRecorder,
+) *PatchUtil {
+	u := NewPatchUtil(client, clientset, kubectlUtil, ctx, req, scheme, log, &patchv1alpha1.NamespacedName{Name: name}, recorder)
+	u.clusterScoped = true
+	return u
+}
+
 func (u *PatchUtil) InitializeFinalizerProbe(patch *patchv1alpha1.Patch) bool {
 	return !controllerutil.ContainsFinalizer(patch, patchv1alpha1.PatchFinalizer)
 }
 
 func (u *PatchUtil) InitializeFinalizer(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
-	controllerutil.AddFinalizer(patch, patchv1alpha1.PatchFinalizer)
-	if err := u.update(patch); err != nil {
+	if err := u.update(patch, func(patch *patchv1alpha1.Patch) {
+		controllerutil.AddFinalizer(patch, patchv1alpha1.PatchFinalizer)
+	}); err != nil {
 		return u.Error(err)
 	}
 	return ctrl.Result{}, nil
@@ -107,59 +134,357 @@ func (u *PatchUtil) Pause(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
 	return ctrl.Result{}, nil
 }
 
+// DependencyProbe returns a message describing the dependencies the patch is
+// waiting for or an empty string if every dependency succeeded. cluster patches
+// are validated first, since the webhook that rejects them may be disabled
+func (u *PatchUtil) DependencyProbe(patch *patchv1alpha1.Patch) (string, error) {
+	if u.clusterScoped {
+		if err := ValidateClusterPatchSpec(&patch.Spec); err != nil {
+			return "", err
+		}
+	}
+	if len(patch.Spec.DependsOn) == 0 {
+		return "", nil
+	}
+	cycle, err := FindDependencyCycle(patch, u.getPatch)
+	if err != nil {
+		return "", err
+	}
+	if cycle != nil {
+		names := []string{}
+		for _, namespacedName := range cycle {
+			names = append(names, namespacedName.String())
+		}
+		return "", errors.New(fmt.Sprintf("dependency cycle %s", strings.Join(names, " -> ")))
+	}
+	waiting := []string{}
+	for _, dependency := range patch.Spec.DependsOn {
+		namespacedName := DependencyNamespacedName(patch, &dependency)
+		dependencyPatch, err := u.getPatch(namespacedName)
+		if err != nil {
+			if k8sErrors.IsNotFound(err) {
+				waiting = append(waiting, fmt.Sprintf("%s (not found)", namespacedName.String()))
+				continue
+			}
+			return "", err
+		}
+		if dependencyPatch.Status.Phase != patchv1alpha1.SucceededPhase {
+			waiting = append(waiting, fmt.Sprintf("%s (%s)", namespacedName.String(),
+				Default(string(dependencyPatch.Status.Phase), string(patchv1alpha1.PendingPhase))))
+		}
+	}
+	if len(waiting) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("waiting for %s", strings.Join(waiting, ", ")), nil
+}
+
+// Depend holds the patch in the waiting phase until its dependencies succeed.
+// the patch is reconciled again when a dependency changes
+func (u *PatchUtil) Depend(patch *patchv1alpha1.Patch, message string) (ctrl.Result, error) {
+	if patch.Status.Phase == patchv1alpha1.WaitingPhase && patch.Status.Message == message {
+		return ctrl.Result{}, nil
+	}
+	patch.Status.Phase = patchv1alpha1.WaitingPhase
+	patch.Status.Message = message
+	if err := u.updateStatus(patch, false); err != nil {
+		return u.Error(err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// RetryProbe returns true if failed patches are waiting to be retried
+func (u *PatchUtil) RetryProbe(patch *patchv1alpha1.Patch) bool {
+	return patch.Status.NextRetryTime != nil
+}
+
+// Retry waits until the next retry time and then applies the failed patches
+// again. updating the patch stops waiting
+func (u *PatchUtil) Retry(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
+	recalibrate, err := u.RecalibrateProbe(patch)
+	if err != nil {
+		return u.Error(err)
+	}
+	if recalibrate {
+		return u.Recalibrate(patch)
+	}
+	if wait := time.Until(patch.Status.NextRetryTime.Time); wait > 0 {
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+	u.log.Info("retrying patch", "patch", u.namespacedName, "attempt", patch.Status.Attempts+1)
+	for _, conditionType := range patchConditionTypes {
+		meta.RemoveStatusCondition(&patch.Status.Conditions, string(conditionType))
+	}
+	patch.Status.Message = ""
+	patch.Status.Phase = patchv1alpha1.PendingPhase
+	patch.Status.NextRetryTime = nil
+	if err := u.updateStatus(patch, false); err != nil {
+		return u.Error(err)
+	}
+	patchRetries.WithLabelValues(u.metricLabelValues()...).Inc()
+	return ctrl.Result{Requeue: true}, nil
+}
+
 func (u *PatchUtil) PatchingProbe(patch *patchv1alpha1.Patch) bool {
 	return (!u.getConditionStatus(patch, PatchPatching) && !u.getConditionStatus(patch, PatchPatched))
 }
 
 func (u *PatchUtil) Patching(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
-	jobUtil := NewJobUtil(patch, u.ctx, u.scheme)
-	owned, err := jobUtil.Owned()
+	resolvedPatches, err := NewEngineUtil(patch, u.kubectlUtil, u.ctx).Resolve()
 	if err != nil {
 		return u.Error(err)
 	}
-	if !owned {
-		return u.Error(errors.New(fmt.Sprintf("job %s already exists", patch.GetName()+"-patch")))
+	resolvedPatches = u.unappliedPatches(patch, resolvedPatches)
+	resolvedPatches, err = u.dryRun(patch, resolvedPatches)
+	if err != nil {
+		return u.Error(err)
 	}
-	if err := jobUtil.Delete(); err != nil {
+	// a job deleted by a recalibration may still be patching the targets
+	terminating, err := u.newJobUtil(patch).Terminating()
+	if err != nil {
 		return u.Error(err)
 	}
-	scriptUtil := NewScriptUtil(patch)
-	for i, patchItem := range patch.Spec.Patches {
-		patchId := patchItem.Id
-		if patchItem.Id == "" {
-			patchId = fmt.Sprint(i)
+	if terminating != "" {
+		u.log.Info("waiting for job to be deleted", "patch", u.namespacedName, "job", terminating)
+		return ctrl.Result{RequeueAfter: jobDeletionRequeueDuration}, nil
+	}
+	if patch.Spec.Executor == patchv1alpha1.InProcessExecutor {
+		return u.patchInProcess(patch, resolvedPatches)
+	}
+	if len(resolvedPatches) == 0 {
+		return u.UpdateStatusPatched(patch)
+	}
+	run := u.nextRun(patch)
+	jobUtil := u.newJobUtil(patch)
+	jobUtil.SetRun(run)
+	if patch.Spec.RevertOnDelete {
+		if err := u.snapshot(patch, resolvedPatches); err != nil {
+			return u.Error(err)
 		}
-		if err := scriptUtil.AppendPatch(patchId, &patchItem); err != nil {
+	}
+	for _, resolvedPatch := range resolvedPatches {
+		u.setTargetStatus(patch, &resolvedPatch, patchv1alpha1.PendingPatchState, "")
+		u.setPatchStatus(patch, resolvedPatch.Id, patchv1alpha1.PendingPatchState, "", "", "")
+	}
+	if _, err := u.createJob(patch, jobUtil, resolvedPatches); err != nil {
+		// the job of the run was created before the status could be updated
+		if !k8sErrors.IsAlreadyExists(err) {
 			return u.Error(err)
 		}
+	} else {
+		u.event(patch, v1.EventTypeNormal, JobCreatedReason, "created job %s", jobUtil.Name())
 	}
-	jobUtil.Create(scriptUtil.Get(), &[]v1.EnvVar{})
+	now := metav1.Now()
+	patch.Status.LastRun = run
+	patch.Status.Runs = append(patch.Status.Runs, patchv1alpha1.PatchStatusRun{
+		Run:       run,
+		JobName:   jobUtil.Name(),
+		State:     patchv1alpha1.RunningRunState,
+		StartTime: &now,
+	})
 	return u.UpdateStatusPatching(patch)
 }
 
+// createJob creates the job of a run. patches of type script are run by a
+// script in the image of the patch, all other patches by the runner of the
+// operator image
+func (u *PatchUtil) createJob(
+	patch *patchv1alpha1.Patch,
+	jobUtil *JobUtil,
+	resolvedPatches []ResolvedPatch,
+) (*batchv1.Job, error) {
+	for _, resolvedPatch := range resolvedPatches {
+		if resolvedPatch.PatchItem.Type == patchv1alpha1.ScriptPatchType {
+			return u.createScriptJob(patch, jobUtil, resolvedPatches)
+		}
+	}
+	return jobUtil.CreateRunner(u.runnerPatch(patch, resolvedPatches))
+}
+
+func (u *PatchUtil) createScriptJob(
+	patch *patchv1alpha1.Patch,
+	jobUtil *JobUtil,
+	resolvedPatches []ResolvedPatch,
+) (*batchv1.Job, error) {
+	scriptUtil := NewScriptUtil(patch)
+	for _, resolvedPatch := range resolvedPatches {
+		if err := scriptUtil.AppendPatch(resolvedPatch.Id, resolvedPatch.PatchItem); err != nil {
+			return nil, err
+		}
+	}
+	return jobUtil.Create(scriptUtil.Get())
+}
+
+// runnerPatch returns the patch run by the runner. the resolved patches are
+// already rendered, so the runner does not read configmaps, secrets or vars.
+// the references are kept, so the runner redacts patches rendered from secrets
+func (u *PatchUtil) runnerPatch(patch *patchv1alpha1.Patch, resolvedPatches []ResolvedPatch) *patchv1alpha1.Patch {
+	runnerPatch := &patchv1alpha1.Patch{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: patchv1alpha1.GroupVersion.String(),
+			Kind:       "Patch",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      patch.GetName(),
+			Namespace: patch.GetNamespace(),
+		},
+		Spec: patchv1alpha1.PatchSpec{
+			FieldManager: patch.Spec.FieldManager,
+			Force:        patch.Spec.Force,
+		},
+	}
+	for _, resolvedPatch := range resolvedPatches {
+		patchItem := resolvedPatch.PatchItem.DeepCopy()
+		patchItem.Id = resolvedPatch.Id
+		runnerPatch.Spec.Patches = append(runnerPatch.Spec.Patches, *patchItem)
+	}
+	return runnerPatch
+}
+
+func (u *PatchUtil) patchInProcess(
+	patch *patchv1alpha1.Patch,
+	resolvedPatches []ResolvedPatch,
+) (ctrl.Result, error) {
+	engineUtil := NewEngineUtil(patch, u.kubectlUtil, u.ctx)
+	waiting, err := engineUtil.Waiting()
+	if err != nil {
+		return u.Error(err)
+	}
+	if len(waiting) > 0 {
+		for _, patchId := range waiting {
+			u.setPatchStatus(patch, patchId, patchv1alpha1.WaitingPatchState, "", "", "")
+		}
+		if err := u.updateStatus(patch, false); err != nil {
+			return u.Error(err)
+		}
+		return ctrl.Result{
+			Requeue:      true,
+			RequeueAfter: config.DefaultRequeueAfter,
+		}, nil
+	}
+	if patch.Spec.RevertOnDelete {
+		if err := u.snapshot(patch, resolvedPatches); err != nil {
+			return u.Error(err)
+		}
+		if err := u.updateStatus(patch, false); err != nil {
+			return u.Error(err)
+		}
+	}
+	for _, resolvedPatch := range resolvedPatches {
+		u.setPatchStatus(patch, resolvedPatch.Id, patchv1alpha1.PendingPatchState, "", "", "")
+	}
+	startTime := time.Now()
+	for _, resolvedPatch := range resolvedPatches {
+		if remaining := u.waitRemaining(patch, resolvedPatch.Id, resolvedPatch.PatchItem); remaining > 0 {
+			// the patches applied so far are kept and the rest are applied
+			// when the patch is reconciled again after the timeout
+			if err := u.updateStatus(patch, false); err != nil {
+				return u.Error(err)
+			}
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+		patched, reason, err := engineUtil.Apply(resolvedPatch.Id, resolvedPatch.PatchItem)
+		if err != nil {
+			u.setTargetStatus(patch, &resolvedPatch, patchv1alpha1.FailedPatchState, err.Error())
+			u.setPatchStatus(patch, resolvedPatch.Id, patchv1alpha1.FailedPatchState, "", "", err.Error())
+			u.observeDuration(time.Since(startTime))
+			return u.fail(patch, err, nil)
+		}
+		if patched == nil {
+			u.setTargetStatus(patch, &resolvedPatch, patchv1alpha1.SkippedPatchState, "")
+			u.setPatchStatus(patch, resolvedPatch.Id, patchv1alpha1.SkippedPatchState, reason, "", "")
+		} else {
+			u.setTargetStatus(patch, &resolvedPatch, patchv1alpha1.AppliedPatchState, "")
+			u.setPatchStatus(patch, resolvedPatch.Id, patchv1alpha1.AppliedPatchState, "", patched.GetResourceVersion(), "")
+			u.targetEvent(patch, resolvedPatch.Id, patched)
+		}
+	}
+	u.observeDuration(time.Since(startTime))
+	return u.UpdateStatusPatched(patch)
+}
+
+// waitRemaining returns how long a patch applied in process still waits for
+// its timeout. the wait starts when the patch started or when a patch before it
+// completed, whichever is later
+func (u *PatchUtil) waitRemaining(
+	patch *patchv1alpha1.Patch,
+	patchId string,
+	patchItem *patchv1alpha1.PatchSpecPatch,
+) time.Duration {
+	if patchItem.WaitForTimeout <= 0 {
+		return 0
+	}
+	patchStatus := u.findPatchStatus(patch, patchId)
+	if patchStatus == nil || patchStatus.StartTime == nil {
+		return 0
+	}
+	waitStart := patchStatus.StartTime.Time
+	for i, previousPatchItem := range patch.Spec.Patches {
+		previousId := Default(previousPatchItem.Id, fmt.Sprint(i))
+		if previousId == patchId {
+			break
+		}
+		previousStatus := u.findPatchStatus(patch, previousId)
+		if previousStatus != nil && previousStatus.CompletionTime != nil &&
+			previousStatus.CompletionTime.Time.After(waitStart) {
+			waitStart = previousStatus.CompletionTime.Time
+		}
+	}
+	return time.Until(waitStart.Add(time.Duration(patchItem.WaitForTimeout) * time.Second))
+}
+
 func (u *PatchUtil) PatchedProbe(patch *patchv1alpha1.Patch) bool {
 	return !u.getConditionStatus(patch, PatchPatched)
 }
 
 func (u *PatchUtil) Patched(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
-	jobUtil := NewJobUtil(patch, u.ctx, u.scheme)
+	jobUtil := u.newJobUtil(patch)
 	completed, errorMessage, err := jobUtil.Completed()
 	if err != nil {
 		return u.Error(err)
 	}
+	var results []RunnerResult
+	if completed {
+		u.observeJobDuration(jobUtil)
+		results, err = u.captureLogs(patch, jobUtil)
+		if err != nil {
+			u.log.Error(err, "unable to capture job logs", "patch", u.namespacedName)
+		}
+		u.setRunnerResults(patch, results)
+		state := patchv1alpha1.SucceededRunState
+		if errorMessage != "" {
+			state = patchv1alpha1.FailedRunState
+		}
+		u.completeRun(patch, state)
+		if err := u.pruneRuns(patch); err != nil {
+			return u.Error(err)
+		}
+	}
 	if errorMessage != "" {
-		patch.Status.PauseUntilUpdate = true
-		if err := u.updateErrorStatus(patch, errors.New(errorMessage)); err != nil {
+		if results != nil {
+			for _, result := range results {
+				if result.State == patchv1alpha1.FailedPatchState {
+					errorMessage = fmt.Sprintf("%s: %s", errorMessage, result.Error)
+				}
+			}
+		} else if patch.Status.Logs != nil && patch.Status.Logs.TerminationMessage != "" {
+			errorMessage = fmt.Sprintf("%s: %s", errorMessage, lastLine(patch.Status.Logs.TerminationMessage))
+		}
+		u.setPendingPatchStatus(patch, patchv1alpha1.FailedPatchState, errorMessage)
+		u.setPendingTargetStatus(patch, patchv1alpha1.FailedPatchState, errorMessage)
+		exitCode, err := jobUtil.ExitCode()
+		if err != nil {
 			return u.Error(err)
 		}
-		return ctrl.Result{}, nil
+		return u.fail(patch, errors.New(errorMessage), exitCode)
 	}
 	if !completed {
-		return ctrl.Result{
-			Requeue:      true,
-			RequeueAfter: config.DefaultRequeueAfter,
-		}, nil
+		// the job is owned by the patch, so the patch is reconciled again
+		// when the job changes
+		return ctrl.Result{}, nil
 	}
+	u.setPendingPatchStatus(patch, patchv1alpha1.AppliedPatchState, "")
+	u.setPendingTargetStatus(patch, patchv1alpha1.AppliedPatchState, "")
 	return u.UpdateStatusPatched(patch)
 }
 
@@ -173,35 +498,305 @@ func (u *PatchUtil) RecalibrateProbe(patch *patchv1alpha1.Patch) (bool, error) {
 }
 
 func (u *PatchUtil) Recalibrate(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
-	jobUtil := NewJobUtil(patch, u.ctx, u.scheme)
-	if err := jobUtil.Delete(); err != nil {
+	if err := u.cancelRun(patch); err != nil {
 		return u.Error(err)
 	}
+	u.event(patch, v1.EventTypeNormal, RecalibratedReason, "patching targets again")
 	return u.ResetStatus(patch)
 }
 
+// RematchProbe returns true if resources started matching a patched target
+func (u *PatchUtil) RematchProbe(patch *patchv1alpha1.Patch) (bool, error) {
+	if !HasMultiTargets(patch) || !u.getConditionStatus(patch, PatchPatched) {
+		return false, nil
+	}
+	resolvedPatches, err := NewEngineUtil(patch, u.kubectlUtil, u.ctx).Resolve()
+	if err != nil {
+		return false, err
+	}
+	return len(u.unappliedPatches(patch, resolvedPatches)) > 0, nil
+}
+
+// Rematch patches the resources that started matching without patching the
+// resources that were already patched
+func (u *PatchUtil) Rematch(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
+	for _, conditionType := range patchConditionTypes {
+		meta.RemoveStatusCondition(&patch.Status.Conditions, string(conditionType))
+	}
+	patch.Status.Message = ""
+	patch.Status.Phase = patchv1alpha1.PendingPhase
+	if err := u.updateStatus(patch, false); err != nil {
+		return u.Error(err)
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+func (u *PatchUtil) DriftProbe(patch *patchv1alpha1.Patch) (bool, error) {
+	if patch.Spec.ReconcileMode != patchv1alpha1.ContinuousReconcileMode ||
+		!u.getConditionStatus(patch, PatchPatched) {
+		return false, nil
+	}
+	return NewEngineUtil(patch, u.kubectlUtil, u.ctx).Drifted()
+}
+
+func (u *PatchUtil) Drift(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
+	u.log.Info("patch target drifted", "patch", u.namespacedName)
+	patchDriftCorrections.WithLabelValues(u.metricLabelValues()...).Inc()
+	return u.Recalibrate(patch)
+}
+
+// ScheduleProbe returns true if the patches are due to be applied again on schedule
+func (u *PatchUtil) ScheduleProbe(patch *patchv1alpha1.Patch) (bool, error) {
+	if !u.getConditionStatus(patch, PatchPatched) {
+		return false, nil
+	}
+	next, err := u.nextScheduledTime(patch)
+	if err != nil || next == nil {
+		return false, err
+	}
+	return !time.Now().Before(*next), nil
+}
+
+func (u *PatchUtil) Schedule(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
+	u.log.Info("applying scheduled patch", "patch", u.namespacedName)
+	now := metav1.Now()
+	patch.Status.LastScheduledTime = &now
+	patch.Status.NextScheduledTime = nil
+	return u.Recalibrate(patch)
+}
+
+// Scheduled records the next time the patches will be applied again and
+// requeues the patch for that time
+func (u *PatchUtil) Scheduled(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
+	next, err := u.nextScheduledTime(patch)
+	if err != nil {
+		return u.Error(err)
+	}
+	if next == nil {
+		if patch.Status.NextScheduledTime != nil {
+			patch.Status.NextScheduledTime = nil
+			if err := u.updateStatus(patch, false); err != nil {
+				return u.Error(err)
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+	if patch.Status.NextScheduledTime == nil || !patch.Status.NextScheduledTime.Time.Equal(*next) {
+		nextScheduledTime := metav1.NewTime(*next)
+		patch.Status.NextScheduledTime = &nextScheduledTime
+		if err := u.updateStatus(patch, false); err != nil {
+			return u.Error(err)
+		}
+	}
+	return ctrl.Result{RequeueAfter: time.Until(*next)}, nil
+}
+
 func (u *PatchUtil) FinalizeProbe(patch *patchv1alpha1.Patch) bool {
 	return patch.GetDeletionTimestamp() != nil
 }
 
 func (u *PatchUtil) Finalize(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
 	if controllerutil.ContainsFinalizer(patch, patchv1alpha1.PatchFinalizer) {
-		controllerutil.RemoveFinalizer(patch, patchv1alpha1.PatchFinalizer)
-		if err := u.update(patch); err != nil {
+		reverted := 0
+		if patch.Spec.RevertOnDelete {
+			var err error
+			if reverted, err = u.revert(patch); err != nil {
+				if _err := u.updateErrorStatus(patch, err); _err != nil {
+					return u.Error(_err)
+				}
+				return ctrl.Result{
+					Requeue:      true,
+					RequeueAfter: CalculateExponentialRequireAfter(patch.Status.LastUpdate, 2),
+				}, nil
+			}
+		}
+		if err := u.update(patch, func(patch *patchv1alpha1.Patch) {
+			controllerutil.RemoveFinalizer(patch, patchv1alpha1.PatchFinalizer)
+		}); err != nil {
 			return u.Error(err)
 		}
+		if patch.Spec.RevertOnDelete {
+			u.event(patch, v1.EventTypeNormal, FinalizedReason, "reverted %d snapshots", reverted)
+		} else {
+			u.event(patch, v1.EventTypeNormal, FinalizedReason, "finalized patch")
+		}
 	}
+	u.DeleteMetrics()
 	return ctrl.Result{}, nil
 }
 
 func (u *PatchUtil) Get() (*patchv1alpha1.Patch, error) {
+	patch, err := u.getPatch(u.namespacedName)
+	if err != nil {
+		return nil, err
+	}
+	u.original = patch.DeepCopy()
+	return patch.DeepCopy(), nil
+}
+
+// getPatch gets a patch or, when cluster scoped, a cluster patch by name
+func (u *PatchUtil) getPatch(namespacedName types.NamespacedName) (*patchv1alpha1.Patch, error) {
 	client := *u.client
 	ctx := *u.ctx
+	if u.clusterScoped {
+		clusterPatch := &patchv1alpha1.ClusterPatch{}
+		if err := client.Get(ctx, types.NamespacedName{Name: namespacedName.Name}, clusterPatch); err != nil {
+			return nil, err
+		}
+		return ClusterPatchToPatch(clusterPatch), nil
+	}
 	patch := &patchv1alpha1.Patch{}
-	if err := client.Get(ctx, u.namespacedName, patch); err != nil {
+	if err := client.Get(ctx, namespacedName, patch); err != nil {
 		return nil, err
 	}
-	return patch.DeepCopy(), nil
+	return patch, nil
+}
+
+// object returns the resource written for the patch, which is a cluster patch
+// when cluster scoped
+func (u *PatchUtil) object(patch *patchv1alpha1.Patch) client.Object {
+	if u.clusterScoped {
+		return PatchToClusterPatch(patch)
+	}
+	return patch
+}
+
+// newJobUtil creates a job util for the job of the last run
+func (u *PatchUtil) newJobUtil(patch *patchv1alpha1.Patch) *JobUtil {
+	jobUtil := NewJobUtil(patch, u.client, u.clientset, u.ctx, u.scheme)
+	if u.clusterScoped {
+		jobUtil.SetOwner(PatchToClusterPatch(patch))
+	}
+	if run := u.lastRun(patch); run != nil {
+		jobUtil.SetName(run.JobName)
+	}
+	return jobUtil
+}
+
+func (u *PatchUtil) newSnapshotUtil(patch *patchv1alpha1.Patch) *SnapshotUtil {
+	snapshotUtil := NewSnapshotUtil(patch, u.clientset, u.ctx, u.scheme)
+	if u.clusterScoped {
+		snapshotUtil.SetOwner(PatchToClusterPatch(patch))
+	}
+	return snapshotUtil
+}
+
+func (u *PatchUtil) lastRun(patch *patchv1alpha1.Patch) *patchv1alpha1.PatchStatusRun {
+	if len(patch.Status.Runs) == 0 {
+		return nil
+	}
+	return &patch.Status.Runs[len(patch.Status.Runs)-1]
+}
+
+// nextRun returns the number of the next run. runs are counted in the status,
+// since the runs in the history are pruned. patches that ran before the count
+// was kept continue after their last run in the history
+func (u *PatchUtil) nextRun(patch *patchv1alpha1.Patch) int32 {
+	lastRun := patch.Status.LastRun
+	if run := u.lastRun(patch); run != nil && run.Run > lastRun {
+		lastRun = run.Run
+	}
+	return lastRun + 1
+}
+
+// completeRun records the outcome and duration of the running run
+func (u *PatchUtil) completeRun(patch *patchv1alpha1.Patch, state patchv1alpha1.RunState) {
+	run := u.lastRun(patch)
+	if run == nil || run.State != patchv1alpha1.RunningRunState {
+		return
+	}
+	now := metav1.Now()
+	run.State = state
+	run.CompletionTime = &now
+	if run.StartTime != nil {
+		run.Duration = now.Sub(run.StartTime.Time).Round(time.Second).String()
+	}
+}
+
+// cancelRun deletes the job of the running run so the patches can run again.
+// jobs created before every run got its own job are always deleted
+func (u *PatchUtil) cancelRun(patch *patchv1alpha1.Patch) error {
+	run := u.lastRun(patch)
+	if run != nil && run.State != patchv1alpha1.RunningRunState {
+		return nil
+	}
+	if err := u.newJobUtil(patch).Delete(); err != nil {
+		return err
+	}
+	if run != nil {
+		patch.Status.Runs = patch.Status.Runs[:len(patch.Status.Runs)-1]
+	}
+	return nil
+}
+
+// pruneRuns deletes the oldest completed runs and their jobs beyond the
+// history limits
+func (u *PatchUtil) pruneRuns(patch *patchv1alpha1.Patch) error {
+	historyLimits := map[patchv1alpha1.RunState]int32{
+		patchv1alpha1.SucceededRunState: SuccessfulRunsHistoryLimit(patch),
+		patchv1alpha1.FailedRunState:    FailedRunsHistoryLimit(patch),
+	}
+	counts := map[patchv1alpha1.RunState]int32{}
+	runs := []patchv1alpha1.PatchStatusRun{}
+	for i := len(patch.Status.Runs) - 1; i >= 0; i-- {
+		run := patch.Status.Runs[i]
+		if historyLimit, ok := historyLimits[run.State]; ok {
+			counts[run.State]++
+			if counts[run.State] > historyLimit {
+				jobUtil := u.newJobUtil(patch)
+				jobUtil.SetName(run.JobName)
+				if err := jobUtil.Delete(); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		runs = append([]patchv1alpha1.PatchStatusRun{run}, runs...)
+	}
+	patch.Status.Runs = runs
+	return nil
+}
+
+// captureLogs records the termination message and the tail of the job logs in
+// the status and stores the full logs in a configmap before the job is cleaned
+// up. it returns the results the runner wrote to the logs, or to the
+// termination message when the logs are gone
+func (u *PatchUtil) captureLogs(patch *patchv1alpha1.Patch, jobUtil *JobUtil) ([]RunnerResult, error) {
+	terminationMessage, logs, err := jobUtil.Logs()
+	if err != nil {
+		return nil, err
+	}
+	results, logs := SplitRunnerResults(logs)
+	if results == nil {
+		results = ParseRunnerResults(terminationMessage)
+	}
+	if terminationMessage == "" && logs == "" {
+		return results, nil
+	}
+	configMapName, err := jobUtil.SaveLogs(logs, LogsHistoryLimit(patch))
+	if err != nil {
+		return results, err
+	}
+	patch.Status.Logs = &patchv1alpha1.PatchStatusLogs{
+		TerminationMessage: terminationMessage,
+		Tail:               tail(logs, LogsTailLines),
+		ConfigMapName:      configMapName,
+	}
+	return results, nil
+}
+
+// observeJobDuration records how long the job ran. the duration is unknown
+// when the job was cleaned up
+func (u *PatchUtil) observeJobDuration(jobUtil *JobUtil) {
+	job, err := jobUtil.Get()
+	if err != nil || job.Status.StartTime == nil {
+		return
+	}
+	completionTime := time.Now()
+	if job.Status.CompletionTime != nil {
+		completionTime = job.Status.CompletionTime.Time
+	}
+	u.observeDuration(completionTime.Sub(job.Status.StartTime.Time))
 }
 
 func (u *PatchUtil) Error(err error) (ctrl.Result, error) {
@@ -217,10 +812,10 @@ func (u *PatchUtil) Error(err error) (ctrl.Result, error) {
 		patch.Status.LastUpdate,
 		2,
 	)
-	if strings.Index(err.Error(), registry.OptimisticLockErrorMsg) <= -1 {
+	if !k8sErrors.IsConflict(err) {
 		u.log.Error(nil, err.Error())
 		if _err := u.updateErrorStatus(patch, err); _err != nil {
-			if strings.Contains(_err.Error(), registry.OptimisticLockErrorMsg) {
+			if k8sErrors.IsConflict(_err) {
 				return ctrl.Result{
 					Requeue:      true,
 					RequeueAfter: requeueAfter,
@@ -239,7 +834,7 @@ func (u *PatchUtil) Error(err error) (ctrl.Result, error) {
 }
 
 func (u *PatchUtil) UpdateStatus(
-	patch *v1alpha1.Patch,
+	patch *patchv1alpha1.Patch,
 	phase patchv1alpha1.Phase,
 	patchConditionType *PatchConditionType,
 ) (ctrl.Result, error) {
@@ -261,17 +856,21 @@ func (u *PatchUtil) UpdateStatus(
 	return ctrl.Result{Requeue: true}, nil
 }
 
-func (u *PatchUtil) UpdateStatusPatching(patch *v1alpha1.Patch) (ctrl.Result, error) {
+func (u *PatchUtil) UpdateStatusPatching(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
 	patchConditionType := PatchPatching
 	return u.UpdateStatus(patch, patchv1alpha1.PendingPhase, &patchConditionType)
 }
 
-func (u *PatchUtil) UpdateStatusPatched(patch *v1alpha1.Patch) (ctrl.Result, error) {
+func (u *PatchUtil) UpdateStatusPatched(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
+	now := metav1.Now()
+	patch.Status.Attempts = 0
+	patch.Status.NextRetryTime = nil
+	patch.Status.LastSuccessTime = &now
 	patchConditionType := PatchPatched
 	return u.UpdateStatus(patch, patchv1alpha1.SucceededPhase, &patchConditionType)
 }
 
-func (u *PatchUtil) ResetStatus(patch *v1alpha1.Patch) (ctrl.Result, error) {
+func (u *PatchUtil) ResetStatus(patch *patchv1alpha1.Patch) (ctrl.Result, error) {
 	for _, conditionType := range patchConditionTypes {
 		meta.RemoveStatusCondition(&patch.Status.Conditions, string(conditionType))
 	}
@@ -279,52 +878,529 @@ func (u *PatchUtil) ResetStatus(patch *v1alpha1.Patch) (ctrl.Result, error) {
 	patch.Status.Phase = ""
 	patch.Status.SpecHash = ""
 	patch.Status.PauseUntilUpdate = false
+	patch.Status.Attempts = 0
+	patch.Status.NextRetryTime = nil
+	patch.Status.Patches = nil
+	patch.Status.Targets = nil
+	patch.Status.TargetsTruncated = false
 	if err := u.updateStatus(patch, false); err != nil {
 		return u.Error(err)
 	}
 	return ctrl.Result{Requeue: true}, nil
 }
 
-func (u *PatchUtil) updateErrorStatus(patch *v1alpha1.Patch, err error) error {
+func (u *PatchUtil) updateErrorStatus(patch *patchv1alpha1.Patch, err error) error {
 	u.setErrorStatus(patch, err)
+	u.event(patch, v1.EventTypeWarning, FailedReason, "%s", err.Error())
 	if _err := u.updateStatus(patch, true); _err != nil {
 		return _err
 	}
 	return nil
 }
 
-func (u *PatchUtil) update(patch *patchv1alpha1.Patch) error {
-	client := *u.client
-	ctx := *u.ctx
-	u.mutex.Lock()
-	if err := client.Update(ctx, patch); err != nil {
-		u.mutex.Unlock()
+// update applies the mutation to the metadata of the patch and writes it as a
+// merge patch. the patch is only written if it did not change since it was
+// read, so a conflict requeues the patch to be reconciled with the latest
+// version
+func (u *PatchUtil) update(patch *patchv1alpha1.Patch, mutate func(patch *patchv1alpha1.Patch)) error {
+	original := u.object(patch.DeepCopy())
+	mutate(patch)
+	obj := u.object(patch)
+	if err := (*u.client).Patch(*u.ctx, obj, client.MergeFromWithOptions(
+		original,
+		client.MergeFromWithOptimisticLock{},
+	)); err != nil {
 		return err
 	}
-	u.mutex.Unlock()
+	patch.SetFinalizers(obj.GetFinalizers())
+	patch.SetResourceVersion(obj.GetResourceVersion())
+	if u.original != nil {
+		u.original.SetFinalizers(obj.GetFinalizers())
+		u.original.SetResourceVersion(obj.GetResourceVersion())
+	}
 	return nil
 }
 
+// updateStatus writes the fields of the status that changed since the patch
+// was read as a merge patch, so fields that did not change are left as they
+// are. the status is only written if the patch did not change since it was
+// read, so a conflict requeues the patch instead of writing over a status
+// the stale patch does not know about
 func (u *PatchUtil) updateStatus(
 	patch *patchv1alpha1.Patch,
 	exponentialBackoff bool,
 ) error {
-	client := *u.client
-	ctx := *u.ctx
 	if !exponentialBackoff ||
 		patch.Status.LastUpdate.IsZero() ||
 		config.StartTime.Unix() > patch.Status.LastUpdate.Unix() {
 		patch.Status.LastUpdate = metav1.Now()
 	}
-	u.mutex.Lock()
-	if err := client.Status().Update(ctx, patch); err != nil {
-		u.mutex.Unlock()
+	original := patch.DeepCopy()
+	original.Status = patchv1alpha1.PatchStatus{}
+	if u.original != nil {
+		original.Status = *u.original.Status.DeepCopy()
+	}
+	obj := u.object(patch)
+	if err := (*u.client).Status().Patch(*u.ctx, obj, client.MergeFromWithOptions(
+		u.object(original),
+		client.MergeFromWithOptimisticLock{},
+	)); err != nil {
 		return err
 	}
-	u.mutex.Unlock()
+	patch.SetResourceVersion(obj.GetResourceVersion())
+	u.original = patch.DeepCopy()
 	return nil
 }
 
+// snapshot records how to revert the changes the patches will make. the
+// snapshot taken before a resource was first patched takes precedence. the
+// snapshots are saved to the secret of the patch, which also migrates the
+// snapshots earlier versions kept in the status
+func (u *PatchUtil) snapshot(patch *patchv1alpha1.Patch, resolvedPatches []ResolvedPatch) error {
+	engineUtil := NewEngineUtil(patch, u.kubectlUtil, u.ctx)
+	snapshotUtil := u.newSnapshotUtil(patch)
+	snapshots, err := snapshotUtil.Get()
+	if err != nil {
+		return err
+	}
+	for _, resolvedPatch := range resolvedPatches {
+		revert, err := engineUtil.Snapshot(resolvedPatch.Id, resolvedPatch.PatchItem)
+		if err != nil {
+			return err
+		}
+		if revert == nil {
+			continue
+		}
+		resource, err := u.kubectlUtil.TargetToResource(resolvedPatch.Id, patch, &resolvedPatch.PatchItem.Target)
+		if err != nil {
+			return err
+		}
+		snapshot := u.findSnapshot(snapshots, resource.GetAPIVersion(), resource.GetKind(), resource.GetName(), resource.GetNamespace())
+		if snapshot == nil {
+			snapshots = append(snapshots, patchv1alpha1.PatchStatusSnapshot{
+				ApiVersion: resource.GetAPIVersion(),
+				Kind:       resource.GetKind(),
+				Name:       resource.GetName(),
+				Namespace:  resource.GetNamespace(),
+				Revert:     string(revert),
+			})
+			continue
+		}
+		merged, err := jsonpatch.MergeMergePatches(revert, []byte(snapshot.Revert))
+		if err != nil {
+			return err
+		}
+		snapshot.Revert = string(merged)
+	}
+	if err := snapshotUtil.Save(snapshots); err != nil {
+		return err
+	}
+	patch.Status.Snapshots = nil
+	return nil
+}
+
+// revert restores the snapshots in the reverse order they were taken and
+// returns the number of snapshots. the errors of the snapshots that failed to
+// revert are saved with the snapshots
+func (u *PatchUtil) revert(patch *patchv1alpha1.Patch) (int, error) {
+	engineUtil := NewEngineUtil(patch, u.kubectlUtil, u.ctx)
+	snapshotUtil := u.newSnapshotUtil(patch)
+	snapshots, err := snapshotUtil.Get()
+	if err != nil {
+		return 0, err
+	}
+	failed := []string{}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snapshot := &snapshots[i]
+		if err := engineUtil.Revert(snapshot); err != nil {
+			snapshot.Message = err.Error()
+			failed = append(failed, fmt.Sprintf("%s %s", snapshot.Kind, snapshot.Name))
+			continue
+		}
+		snapshot.Message = ""
+	}
+	if len(failed) > 0 {
+		if err := snapshotUtil.Save(snapshots); err != nil {
+			return 0, err
+		}
+		return 0, errors.New(fmt.Sprintf("failed to revert %s", strings.Join(failed, ", ")))
+	}
+	return len(snapshots), nil
+}
+
+func (u *PatchUtil) findSnapshot(
+	snapshots []patchv1alpha1.PatchStatusSnapshot,
+	apiVersion string,
+	kind string,
+	name string,
+	namespace string,
+) *patchv1alpha1.PatchStatusSnapshot {
+	for i := range snapshots {
+		snapshot := &snapshots[i]
+		if snapshot.ApiVersion == apiVersion &&
+			snapshot.Kind == kind &&
+			snapshot.Name == name &&
+			snapshot.Namespace == namespace {
+			return snapshot
+		}
+	}
+	return nil
+}
+
+// dryRun records the changes the dry run patches would make to their targets
+// and returns the patches that should actually be applied
+func (u *PatchUtil) dryRun(patch *patchv1alpha1.Patch, resolvedPatches []ResolvedPatch) ([]ResolvedPatch, error) {
+	engineUtil := NewEngineUtil(patch, u.kubectlUtil, u.ctx)
+	remainingPatches := []ResolvedPatch{}
+	dryRunPatches := []ResolvedPatch{}
+	for _, resolvedPatch := range resolvedPatches {
+		if IsDryRun(patch, resolvedPatch.PatchItem) {
+			dryRunPatches = append(dryRunPatches, resolvedPatch)
+			u.setPatchStatus(patch, resolvedPatch.Id, patchv1alpha1.PendingPatchState, "", "", "")
+		} else {
+			remainingPatches = append(remainingPatches, resolvedPatch)
+		}
+	}
+	for _, resolvedPatch := range dryRunPatches {
+		if resolvedPatch.PatchItem.Type == patchv1alpha1.ScriptPatchType {
+			u.setTargetStatus(patch, &resolvedPatch, patchv1alpha1.SkippedPatchState, "script patches cannot be dry run")
+			u.setPatchStatus(patch, resolvedPatch.Id, patchv1alpha1.SkippedPatchState, "script patches cannot be dry run", "", "")
+			continue
+		}
+		diff, reason, err := engineUtil.DryRun(resolvedPatch.Id, resolvedPatch.PatchItem)
+		if err != nil {
+			u.setTargetStatus(patch, &resolvedPatch, patchv1alpha1.DryRunPatchState, err.Error())
+			u.setPatchStatus(patch, resolvedPatch.Id, patchv1alpha1.DryRunPatchState, "", "", err.Error())
+			continue
+		}
+		if reason != "" {
+			u.setTargetStatus(patch, &resolvedPatch, patchv1alpha1.SkippedPatchState, "")
+			u.setPatchStatus(patch, resolvedPatch.Id, patchv1alpha1.SkippedPatchState, reason, "", "")
+			continue
+		}
+		targetStatus := u.setTargetStatus(patch, &resolvedPatch, patchv1alpha1.DryRunPatchState, "")
+		if len(diff) > maxTargetDiffBytes {
+			targetStatus.Message = fmt.Sprintf("the diff of %d bytes is too large to show", len(diff))
+		} else {
+			targetStatus.Diff = diff
+		}
+		u.setPatchStatus(patch, resolvedPatch.Id, patchv1alpha1.DryRunPatchState, "", "", "")
+	}
+	return remainingPatches, nil
+}
+
+// unappliedPatches filters out the patches that were already applied to, skipped for or dry run against their target.
+// targets that are not listed because the targets were truncated fall back to the state of their patch
+func (u *PatchUtil) unappliedPatches(
+	patch *patchv1alpha1.Patch,
+	resolvedPatches []ResolvedPatch,
+) []ResolvedPatch {
+	unappliedPatches := []ResolvedPatch{}
+	for _, resolvedPatch := range resolvedPatches {
+		state := patchv1alpha1.PatchState("")
+		if targetStatus := u.findTargetStatus(patch, &resolvedPatch); targetStatus != nil {
+			state = targetStatus.State
+		} else if patchStatus := u.findPatchStatus(patch, resolvedPatch.Id); patchStatus != nil && patch.Status.TargetsTruncated {
+			state = patchStatus.State
+		}
+		if state == patchv1alpha1.AppliedPatchState ||
+			state == patchv1alpha1.SkippedPatchState ||
+			state == patchv1alpha1.DryRunPatchState {
+			continue
+		}
+		unappliedPatches = append(unappliedPatches, resolvedPatch)
+	}
+	return unappliedPatches
+}
+
+func (u *PatchUtil) findTargetStatus(
+	patch *patchv1alpha1.Patch,
+	resolvedPatch *ResolvedPatch,
+) *patchv1alpha1.PatchStatusTarget {
+	resource, err := u.kubectlUtil.TargetToResource(resolvedPatch.Id, patch, &resolvedPatch.PatchItem.Target)
+	if err != nil {
+		return nil
+	}
+	for i := range patch.Status.Targets {
+		targetStatus := &patch.Status.Targets[i]
+		if targetStatus.Id == resolvedPatch.Id &&
+			targetStatus.ApiVersion == resource.GetAPIVersion() &&
+			targetStatus.Kind == resource.GetKind() &&
+			targetStatus.Name == resource.GetName() &&
+			targetStatus.Namespace == resource.GetNamespace() {
+			return targetStatus
+		}
+	}
+	return nil
+}
+
+func (u *PatchUtil) setTargetStatus(
+	patch *patchv1alpha1.Patch,
+	resolvedPatch *ResolvedPatch,
+	state patchv1alpha1.PatchState,
+	message string,
+) *patchv1alpha1.PatchStatusTarget {
+	u.recordApplication(state)
+	targetStatus := u.findTargetStatus(patch, resolvedPatch)
+	if targetStatus == nil {
+		resource, err := u.kubectlUtil.TargetToResource(resolvedPatch.Id, patch, &resolvedPatch.PatchItem.Target)
+		if err != nil {
+			return &patchv1alpha1.PatchStatusTarget{}
+		}
+		targetStatus = &patchv1alpha1.PatchStatusTarget{
+			Id:         resolvedPatch.Id,
+			ApiVersion: resource.GetAPIVersion(),
+			Kind:       resource.GetKind(),
+			Name:       resource.GetName(),
+			Namespace:  resource.GetNamespace(),
+		}
+		// targets beyond the limit are not listed, so the status stays well
+		// within the size limit of an object
+		if len(patch.Status.Targets) >= MaxTargetStatuses {
+			patch.Status.TargetsTruncated = true
+		} else {
+			patch.Status.Targets = append(patch.Status.Targets, *targetStatus)
+			targetStatus = &patch.Status.Targets[len(patch.Status.Targets)-1]
+		}
+	}
+	targetStatus.State = state
+	targetStatus.Message = message
+	targetStatus.Diff = ""
+	return targetStatus
+}
+
+func (u *PatchUtil) setPendingTargetStatus(
+	patch *patchv1alpha1.Patch,
+	state patchv1alpha1.PatchState,
+	message string,
+) {
+	for i := range patch.Status.Targets {
+		if patch.Status.Targets[i].State == patchv1alpha1.PendingPatchState {
+			u.recordApplication(state)
+			patch.Status.Targets[i].State = state
+			patch.Status.Targets[i].Message = message
+			if state == patchv1alpha1.AppliedPatchState {
+				u.targetStatusEvent(patch, &patch.Status.Targets[i])
+			}
+		}
+	}
+}
+
+func (u *PatchUtil) findPatchStatus(patch *patchv1alpha1.Patch, patchId string) *patchv1alpha1.PatchStatusPatch {
+	for i := range patch.Status.Patches {
+		if patch.Status.Patches[i].Id == patchId {
+			return &patch.Status.Patches[i]
+		}
+	}
+	return nil
+}
+
+// setPatchStatus records the result of a patch. a patch with multiple targets
+// keeps its most severe result until it runs again
+func (u *PatchUtil) setPatchStatus(
+	patch *patchv1alpha1.Patch,
+	patchId string,
+	state patchv1alpha1.PatchState,
+	reason string,
+	resourceVersion string,
+	errorMessage string,
+) {
+	patchStatus := u.findPatchStatus(patch, patchId)
+	if patchStatus == nil {
+		patch.Status.Patches = append(patch.Status.Patches, patchv1alpha1.PatchStatusPatch{Id: patchId})
+		patchStatus = &patch.Status.Patches[len(patch.Status.Patches)-1]
+	}
+	now := metav1.Now()
+	if state == patchv1alpha1.PendingPatchState || state == patchv1alpha1.WaitingPatchState {
+		if patchStatus.CompletionTime != nil || patchStatus.StartTime == nil {
+			patchStatus.StartTime = &now
+		}
+		patchStatus.State = state
+		patchStatus.Reason = ""
+		patchStatus.Error = ""
+		patchStatus.CompletionTime = nil
+		return
+	}
+	if patchStatus.CompletionTime != nil &&
+		(patchStatus.State == patchv1alpha1.FailedPatchState ||
+			(patchStatus.State == patchv1alpha1.AppliedPatchState && state == patchv1alpha1.SkippedPatchState)) {
+		return
+	}
+	if patchStatus.StartTime == nil {
+		patchStatus.StartTime = &now
+	}
+	patchStatus.State = state
+	patchStatus.Reason = reason
+	patchStatus.Error = errorMessage
+	if resourceVersion != "" {
+		patchStatus.ResourceVersion = resourceVersion
+	}
+	patchStatus.CompletionTime = &now
+	if state == patchv1alpha1.AppliedPatchState {
+		u.event(patch, v1.EventTypeNormal, AppliedReason, "applied patch %s", patchId)
+	} else if state == patchv1alpha1.SkippedPatchState {
+		u.event(patch, v1.EventTypeNormal, SkippedReason, "skipped patch %s: %s", patchId, reason)
+	}
+}
+
+// setRunnerResults records the results the runner reported for the targets of
+// the patches. the patches the runner did not reach stay pending
+func (u *PatchUtil) setRunnerResults(patch *patchv1alpha1.Patch, results []RunnerResult) {
+	for _, result := range results {
+		resolvedPatch := &ResolvedPatch{
+			Id: result.Id,
+			PatchItem: &patchv1alpha1.PatchSpecPatch{
+				Target: patchv1alpha1.Target{
+					ApiVersion: result.ApiVersion,
+					Kind:       result.Kind,
+					Name:       result.Name,
+					Namespace:  result.Namespace,
+				},
+			},
+		}
+		targetStatus := u.setTargetStatus(patch, resolvedPatch, result.State, result.Error)
+		u.setPatchStatus(patch, result.Id, result.State, result.Reason, result.ResourceVersion, result.Error)
+		if result.State == patchv1alpha1.AppliedPatchState {
+			u.targetStatusEvent(patch, targetStatus)
+		}
+	}
+}
+
+// setPendingPatchStatus completes the patches run by a job. jobs running a
+// script do not report back, so the resource versions are read from the targets
+func (u *PatchUtil) setPendingPatchStatus(
+	patch *patchv1alpha1.Patch,
+	state patchv1alpha1.PatchState,
+	errorMessage string,
+) {
+	engineUtil := NewEngineUtil(patch, u.kubectlUtil, u.ctx)
+	for i := range patch.Status.Patches {
+		patchId := patch.Status.Patches[i].Id
+		if patch.Status.Patches[i].State != patchv1alpha1.PendingPatchState {
+			continue
+		}
+		resourceVersion := ""
+		if state == patchv1alpha1.AppliedPatchState {
+			for _, targetStatus := range patch.Status.Targets {
+				if targetStatus.Id != patchId || targetStatus.State != patchv1alpha1.PendingPatchState {
+					continue
+				}
+				resourceVersion, _ = engineUtil.ResourceVersion(patchId, &patchv1alpha1.Target{
+					ApiVersion: targetStatus.ApiVersion,
+					Kind:       targetStatus.Kind,
+					Name:       targetStatus.Name,
+					Namespace:  targetStatus.Namespace,
+				})
+			}
+		}
+		u.setPatchStatus(patch, patchId, state, "", resourceVersion, errorMessage)
+	}
+}
+
+// fail schedules a retry of the failed patches when the retry policy allows
+// it, otherwise the patch is paused until it is updated
+func (u *PatchUtil) fail(patch *patchv1alpha1.Patch, err error, exitCode *int32) (ctrl.Result, error) {
+	specHash, _err := u.getSpecHash(patch)
+	if _err != nil {
+		return u.Error(_err)
+	}
+	patch.Status.SpecHash = specHash
+	patch.Status.Attempts++
+	backoff, retry, _err := u.retryBackoff(patch, exitCode)
+	if _err != nil {
+		err = errors.New(fmt.Sprintf("%s: %s", err.Error(), _err.Error()))
+	}
+	if retry {
+		nextRetryTime := metav1.NewTime(time.Now().Add(backoff))
+		patch.Status.NextRetryTime = &nextRetryTime
+		if err := u.updateErrorStatus(patch, err); err != nil {
+			return u.Error(err)
+		}
+		return ctrl.Result{RequeueAfter: backoff}, nil
+	}
+	patch.Status.PauseUntilUpdate = true
+	if err := u.updateErrorStatus(patch, err); err != nil {
+		return u.Error(err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// retryBackoff returns the backoff before the next attempt and true if the
+// failed patches should be retried
+func (u *PatchUtil) retryBackoff(patch *patchv1alpha1.Patch, exitCode *int32) (time.Duration, bool, error) {
+	retryPolicy := patch.Spec.RetryPolicy
+	if retryPolicy == nil {
+		return 0, false, nil
+	}
+	if retryPolicy.MaxAttempts > 0 && patch.Status.Attempts >= retryPolicy.MaxAttempts {
+		return 0, false, nil
+	}
+	if len(retryPolicy.RetryOnExitCodes) > 0 && exitCode != nil {
+		retryable := false
+		for _, retryOnExitCode := range retryPolicy.RetryOnExitCodes {
+			if retryOnExitCode == *exitCode {
+				retryable = true
+			}
+		}
+		if !retryable {
+			return 0, false, nil
+		}
+	}
+	backoffBase, err := time.ParseDuration(Default(retryPolicy.BackoffBase, "10s"))
+	if err != nil {
+		return 0, false, errors.New(fmt.Sprintf("invalid backoff base %s", retryPolicy.BackoffBase))
+	}
+	backoffMax, err := time.ParseDuration(Default(retryPolicy.BackoffMax, "10m"))
+	if err != nil {
+		return 0, false, errors.New(fmt.Sprintf("invalid backoff max %s", retryPolicy.BackoffMax))
+	}
+	backoff := time.Duration(math.Min(
+		float64(backoffBase)*math.Pow(2, float64(patch.Status.Attempts-1)),
+		float64(backoffMax),
+	))
+	if retryPolicy.Jitter > 0 {
+		jitter := float64(backoff) * float64(retryPolicy.Jitter) / 100
+		backoff += time.Duration(jitter * (2*rand.Float64() - 1))
+	}
+	return backoff, true, nil
+}
+
+// nextScheduledTime returns the next time the patches should be applied again
+// after they were last applied or nil if they are not scheduled
+func (u *PatchUtil) nextScheduledTime(patch *patchv1alpha1.Patch) (*time.Time, error) {
+	if patch.Spec.Schedule == "" && patch.Spec.ReapplyInterval == "" {
+		return nil, nil
+	}
+	condition := u.getCondition(patch, PatchPatched)
+	if condition == nil {
+		return nil, nil
+	}
+	last := condition.LastTransitionTime.Time
+	var next *time.Time
+	if patch.Spec.Schedule != "" {
+		cronSchedule, err := ParseCronSchedule(patch.Spec.Schedule, patch.Spec.TimeZone)
+		if err != nil {
+			return nil, err
+		}
+		if scheduled := cronSchedule.Next(last); !scheduled.IsZero() {
+			next = &scheduled
+		}
+	}
+	if patch.Spec.ReapplyInterval != "" {
+		reapplyInterval, err := time.ParseDuration(patch.Spec.ReapplyInterval)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid reapply interval %s", patch.Spec.ReapplyInterval))
+		}
+		if reapplyInterval > 0 {
+			reapply := last.Add(reapplyInterval)
+			if next == nil || reapply.Before(*next) {
+				next = &reapply
+			}
+		}
+	}
+	return next, nil
+}
+
 func (u *PatchUtil) getConditionStatus(patch *patchv1alpha1.Patch, patchConditionType PatchConditionType) bool {
 	condition := u.getCondition(patch, patchConditionType)
 	if condition == nil {
@@ -345,6 +1421,17 @@ func (u *PatchUtil) getSpecHash(patch *patchv1alpha1.Patch) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if HasPatchFrom(patch) {
+		contents, err := NewEngineUtil(patch, u.kubectlUtil, u.ctx).PatchFromContents()
+		if err != nil {
+			return "", err
+		}
+		bContents, err := json.Marshal(contents)
+		if err != nil {
+			return "", err
+		}
+		bSpec = append(bSpec, bContents...)
+	}
 	return strconv.FormatUint(xxhash.Sum64(bSpec), 16), nil
 }
 
@@ -405,8 +1492,6 @@ func (u *PatchUtil) setErrorStatus(patch *patchv1alpha1.Patch, err error) {
 	patch.Status.Message = message
 }
 
-var GlobalPatchMutex *sync.Mutex = &sync.Mutex{}
-
 type PatchConditionType string
 
 const (
@@ -416,3 +1501,13 @@ const (
 )
 
 var patchConditionTypes []PatchConditionType = []PatchConditionType{PatchFailed, PatchPatched, PatchPatching}
+
+// jobDeletionRequeueDuration is how long to wait for a job being deleted before
+// checking again. the deletion of an owned job also requeues the patch
+const jobDeletionRequeueDuration = time.Duration(time.Second * 5)
+
+// MaxTargetStatuses is the most targets listed in the status of a patch
+const MaxTargetStatuses = 100
+
+// maxTargetDiffBytes is the largest dry run diff shown in the status of a target
+const maxTargetDiffBytes = 4096
@@ -0,0 +1,591 @@
+/**
+ * File: /patch_test.go
+ * Project: util
+ * File Created: 17-10-2026 06:38:25
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// slowClient delays every status write like a busy api server
+type slowClient struct {
+	client.Client
+	latency time.Duration
+}
+
+func (c *slowClient) Status() client.StatusWriter {
+	return &slowStatusWriter{StatusWriter: c.Client.Status(), latency: c.latency}
+}
+
+type slowStatusWriter struct {
+	client.StatusWriter
+	latency time.Duration
+}
+
+func (w *slowStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	time.Sleep(w.latency)
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
+
+func newTestPatchUtil(t *testing.T, c *client.Client, name string) *PatchUtil {
+	ctx := context.Background()
+	return NewPatchUtil(
+		c,
+		kubefake.NewSimpleClientset(),
+		NewKubectlUtil(&rest.Config{}, newStaticRESTMapper(), nil),
+		&ctx,
+		&ctrl.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "default"}},
+		newTestScheme(t),
+		log.Log,
+		&patchv1alpha1.NamespacedName{Name: name, Namespace: "default"},
+		record.NewFakeRecorder(10),
+	)
+}
+
+func TestWaitRemaining(t *testing.T) {
+	now := time.Now()
+	ago := func(seconds int) *metav1.Time {
+		result := metav1.NewTime(now.Add(-time.Duration(seconds) * time.Second))
+		return &result
+	}
+	tests := []struct {
+		name     string
+		timeout  int
+		statuses []patchv1alpha1.PatchStatusPatch
+		min      time.Duration
+		max      time.Duration
+	}{
+		{
+			name:    "no timeout",
+			timeout: 0,
+		},
+		{
+			name:    "not started",
+			timeout: 30,
+		},
+		{
+			name:     "waiting since the patch started",
+			timeout:  30,
+			statuses: []patchv1alpha1.PatchStatusPatch{{Id: "second", StartTime: ago(10)}},
+			min:      19 * time.Second,
+			max:      20 * time.Second,
+		},
+		{
+			name:    "waiting since the previous patch completed",
+			timeout: 30,
+			statuses: []patchv1alpha1.PatchStatusPatch{
+				{Id: "first", StartTime: ago(60), CompletionTime: ago(5)},
+				{Id: "second", StartTime: ago(60)},
+			},
+			min: 24 * time.Second,
+			max: 25 * time.Second,
+		},
+		{
+			name:    "timeout elapsed",
+			timeout: 30,
+			statuses: []patchv1alpha1.PatchStatusPatch{
+				{Id: "first", StartTime: ago(90), CompletionTime: ago(60)},
+				{Id: "second", StartTime: ago(90)},
+			},
+			max: 0,
+		},
+		{
+			name:    "later patches are ignored",
+			timeout: 30,
+			statuses: []patchv1alpha1.PatchStatusPatch{
+				{Id: "second", StartTime: ago(40)},
+				{Id: "third", StartTime: ago(40), CompletionTime: ago(1)},
+			},
+			max: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := &patchv1alpha1.Patch{
+				Spec: patchv1alpha1.PatchSpec{
+					Patches: []patchv1alpha1.PatchSpecPatch{
+						{Id: "first"},
+						{Id: "second", WaitForTimeout: test.timeout},
+						{Id: "third"},
+					},
+				},
+				Status: patchv1alpha1.PatchStatus{Patches: test.statuses},
+			}
+			remaining := (&PatchUtil{}).waitRemaining(patch, "second", &patch.Spec.Patches[1])
+			if remaining > test.max || (test.min > 0 && remaining < test.min) {
+				t.Fatalf("expected remaining wait between %s and %s, got %s", test.min, test.max, remaining)
+			}
+		})
+	}
+}
+
+func TestUpdateStatusKeepsUnchangedFields(t *testing.T) {
+	nextScheduledTime := metav1.NewTime(time.Now().Add(time.Hour))
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Status: patchv1alpha1.PatchStatus{
+			Phase:             patchv1alpha1.PendingPhase,
+			Message:           "written by an earlier reconcile",
+			NextScheduledTime: &nextScheduledTime,
+		},
+	}
+	var c client.Client = fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(patch).Build()
+	patchUtil := newTestPatchUtil(t, &c, "web")
+	current, err := patchUtil.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Status.Phase = patchv1alpha1.SucceededPhase
+	current.Status.NextScheduledTime = nil
+	if err := patchUtil.updateStatus(current, false); err != nil {
+		t.Fatal(err)
+	}
+	latest := &patchv1alpha1.Patch{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "web", Namespace: "default"}, latest); err != nil {
+		t.Fatal(err)
+	}
+	if latest.Status.Phase != patchv1alpha1.SucceededPhase {
+		t.Fatalf("expected phase %s, got %s", patchv1alpha1.SucceededPhase, latest.Status.Phase)
+	}
+	if latest.Status.NextScheduledTime != nil {
+		t.Fatal("expected next scheduled time to be cleared")
+	}
+	if latest.Status.Message != "written by an earlier reconcile" {
+		t.Fatalf("expected unchanged message to be kept, got %q", latest.Status.Message)
+	}
+}
+
+func TestUpdateStatusConflicts(t *testing.T) {
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Status:     patchv1alpha1.PatchStatus{Phase: patchv1alpha1.PendingPhase},
+	}
+	var c client.Client = fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(patch).Build()
+	patchUtil := newTestPatchUtil(t, &c, "web")
+	stale, err := patchUtil.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a write the stale patch does not know about
+	latest := &patchv1alpha1.Patch{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "web", Namespace: "default"}, latest); err != nil {
+		t.Fatal(err)
+	}
+	latest.Status.Phase = patchv1alpha1.FailedPhase
+	if err := c.Status().Update(context.Background(), latest); err != nil {
+		t.Fatal(err)
+	}
+
+	stale.Status.Phase = patchv1alpha1.SucceededPhase
+	conflict := patchUtil.updateStatus(stale, false)
+	if !k8sErrors.IsConflict(conflict) {
+		t.Fatalf("expected a conflict, got %v", conflict)
+	}
+	latest = &patchv1alpha1.Patch{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "web", Namespace: "default"}, latest); err != nil {
+		t.Fatal(err)
+	}
+	if latest.Status.Phase != patchv1alpha1.FailedPhase {
+		t.Fatalf("expected phase %s to be kept, got %s", patchv1alpha1.FailedPhase, latest.Status.Phase)
+	}
+	result, err := patchUtil.Error(conflict)
+	if err != nil || !result.Requeue {
+		t.Fatalf("expected the patch to be requeued, got %v %v", result, err)
+	}
+}
+
+func TestUpdateConflicts(t *testing.T) {
+	patch := &patchv1alpha1.Patch{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	var c client.Client = fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(patch).Build()
+	patchUtil := newTestPatchUtil(t, &c, "web")
+	stale, err := patchUtil.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := stale.DeepCopy()
+	latest.SetLabels(map[string]string{"team": "payments"})
+	if err := c.Update(context.Background(), latest); err != nil {
+		t.Fatal(err)
+	}
+	err = patchUtil.update(stale, func(patch *patchv1alpha1.Patch) {
+		patch.SetFinalizers([]string{patchv1alpha1.PatchFinalizer})
+	})
+	if !k8sErrors.IsConflict(err) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+}
+
+// TestStatusWritesScaleWithWorkers writes the status of many patches against
+// an api server with a fixed latency per write. the writes of different
+// patches must not be serialized, so more workers write them faster
+func TestStatusWritesScaleWithWorkers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping load test in short mode")
+	}
+	const patches = 32
+	const latency = 20 * time.Millisecond
+	run := func(workers int) time.Duration {
+		objects := []client.Object{}
+		for i := 0; i < patches; i++ {
+			objects = append(objects, &patchv1alpha1.Patch{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("patch-%d", i), Namespace: "default"},
+			})
+		}
+		var c client.Client = &slowClient{
+			Client:  fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objects...).Build(),
+			latency: latency,
+		}
+		queue := make(chan string, patches)
+		for _, obj := range objects {
+			queue <- obj.GetName()
+		}
+		close(queue)
+		errs := make(chan error, patches)
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for name := range queue {
+					patchUtil := newTestPatchUtil(t, &c, name)
+					patch, err := patchUtil.Get()
+					if err != nil {
+						errs <- err
+						continue
+					}
+					patch.Status.Phase = patchv1alpha1.SucceededPhase
+					if err := patchUtil.updateStatus(patch, false); err != nil {
+						errs <- err
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		elapsed := time.Since(start)
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+		return elapsed
+	}
+	serial := run(1)
+	concurrent := run(8)
+	t.Logf("%d status writes took %s with 1 worker and %s with 8 workers", patches, serial, concurrent)
+	if concurrent*3 > serial {
+		t.Fatalf("expected 8 workers to write at least 3 times faster than 1 worker, got %s and %s", concurrent, serial)
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		lastRun int32
+		runs    []int32
+		next    int32
+	}{
+		{name: "first run", next: 1},
+		{name: "after the last run", lastRun: 2, runs: []int32{1, 2}, next: 3},
+		{name: "history pruned to empty", lastRun: 5, next: 6},
+		{name: "history pruned to older runs", lastRun: 5, runs: []int32{3}, next: 6},
+		{name: "runs counted before the last run was kept", runs: []int32{3, 4}, next: 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := &patchv1alpha1.Patch{Status: patchv1alpha1.PatchStatus{LastRun: test.lastRun}}
+			for _, run := range test.runs {
+				patch.Status.Runs = append(patch.Status.Runs, patchv1alpha1.PatchStatusRun{Run: run})
+			}
+			if next := (&PatchUtil{}).nextRun(patch); next != test.next {
+				t.Fatalf("expected next run %d, got %d", test.next, next)
+			}
+		})
+	}
+}
+
+func TestPruneRuns(t *testing.T) {
+	succeeded := patchv1alpha1.SucceededRunState
+	failed := patchv1alpha1.FailedRunState
+	running := patchv1alpha1.RunningRunState
+	one := int32(1)
+	zero := int32(0)
+	tests := []struct {
+		name            string
+		successfulLimit *int32
+		failedLimit     *int32
+		states          []patchv1alpha1.RunState
+		kept            []int32
+	}{
+		{
+			name:   "within the default limits",
+			states: []patchv1alpha1.RunState{succeeded, failed, succeeded},
+			kept:   []int32{1, 2, 3},
+		},
+		{
+			name:            "oldest successful runs pruned",
+			successfulLimit: &one,
+			states:          []patchv1alpha1.RunState{succeeded, failed, succeeded, succeeded},
+			kept:            []int32{2, 4},
+		},
+		{
+			name:            "running run kept",
+			successfulLimit: &zero,
+			failedLimit:     &zero,
+			states:          []patchv1alpha1.RunState{succeeded, failed, running},
+			kept:            []int32{3},
+		},
+		{
+			name:            "history pruned to empty",
+			successfulLimit: &zero,
+			failedLimit:     &zero,
+			states:          []patchv1alpha1.RunState{failed, succeeded},
+			kept:            []int32{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := &patchv1alpha1.Patch{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "patch-uid"},
+				Spec: patchv1alpha1.PatchSpec{
+					SuccessfulRunsHistoryLimit: test.successfulLimit,
+					FailedRunsHistoryLimit:     test.failedLimit,
+				},
+			}
+			objects := []client.Object{}
+			for i, state := range test.states {
+				run := int32(i + 1)
+				jobName := fmt.Sprintf("web-patch-%d", run)
+				patch.Status.Runs = append(patch.Status.Runs, patchv1alpha1.PatchStatusRun{Run: run, JobName: jobName, State: state})
+				objects = append(objects, &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+					Name:            jobName,
+					Namespace:       "default",
+					OwnerReferences: []metav1.OwnerReference{{Kind: "Patch", Name: "web", UID: "patch-uid"}},
+				}})
+			}
+			patch.Status.LastRun = int32(len(test.states))
+			var c client.Client = fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objects...).Build()
+			patchUtil := newTestPatchUtil(t, &c, "web")
+			if err := patchUtil.pruneRuns(patch); err != nil {
+				t.Fatal(err)
+			}
+			kept := []int32{}
+			for _, run := range patch.Status.Runs {
+				kept = append(kept, run.Run)
+			}
+			if fmt.Sprint(kept) != fmt.Sprint(test.kept) {
+				t.Fatalf("expected runs %v to be kept, got %v", test.kept, kept)
+			}
+			jobList := &batchv1.JobList{}
+			if err := c.List(context.Background(), jobList); err != nil {
+				t.Fatal(err)
+			}
+			if len(jobList.Items) != len(test.kept) {
+				t.Fatalf("expected the jobs of %d runs to be kept, got %d", len(test.kept), len(jobList.Items))
+			}
+			if next := patchUtil.nextRun(patch); next != int32(len(test.states))+1 {
+				t.Fatalf("expected the next run to be %d after pruning, got %d", len(test.states)+1, next)
+			}
+		})
+	}
+}
+
+func TestSetTargetStatusTruncates(t *testing.T) {
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+	}
+	var c client.Client = fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	patchUtil := newTestPatchUtil(t, &c, "web")
+	resolvedPatches := []ResolvedPatch{}
+	for i := 0; i < MaxTargetStatuses+5; i++ {
+		resolvedPatches = append(resolvedPatches, ResolvedPatch{
+			Id: "replicas",
+			PatchItem: &patchv1alpha1.PatchSpecPatch{
+				Target: patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: fmt.Sprintf("web-%d", i)},
+			},
+		})
+	}
+	for i := range resolvedPatches {
+		targetStatus := patchUtil.setTargetStatus(patch, &resolvedPatches[i], patchv1alpha1.AppliedPatchState, "")
+		if targetStatus.Name != resolvedPatches[i].PatchItem.Target.Name {
+			t.Fatalf("expected the status of target %s, got %s", resolvedPatches[i].PatchItem.Target.Name, targetStatus.Name)
+		}
+	}
+	if len(patch.Status.Targets) != MaxTargetStatuses || !patch.Status.TargetsTruncated {
+		t.Fatalf("expected %d targets to be listed and the targets to be truncated, got %d", MaxTargetStatuses, len(patch.Status.Targets))
+	}
+	if unapplied := patchUtil.unappliedPatches(patch, resolvedPatches); len(unapplied) != 5 {
+		t.Fatalf("expected the 5 unlisted targets to be unapplied before their patch completed, got %d", len(unapplied))
+	}
+	patchUtil.setPatchStatus(patch, "replicas", patchv1alpha1.AppliedPatchState, "", "", "")
+	if unapplied := patchUtil.unappliedPatches(patch, resolvedPatches); len(unapplied) != 0 {
+		t.Fatalf("expected the unlisted targets to be applied with their patch, got %d unapplied", len(unapplied))
+	}
+}
+
+func TestSnapshotMigratesStatus(t *testing.T) {
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "patch-uid"},
+		Spec:       patchv1alpha1.PatchSpec{RevertOnDelete: true},
+		Status: patchv1alpha1.PatchStatus{
+			Snapshots: []patchv1alpha1.PatchStatusSnapshot{
+				{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web", Namespace: "default", Revert: `{"spec":{"replicas":1}}`},
+			},
+		},
+	}
+	var c client.Client = fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	patchUtil := newTestPatchUtil(t, &c, "web")
+	if err := patchUtil.snapshot(patch, []ResolvedPatch{}); err != nil {
+		t.Fatal(err)
+	}
+	if patch.Status.Snapshots != nil {
+		t.Fatalf("expected the snapshots to be moved out of the status, got %v", patch.Status.Snapshots)
+	}
+	snapshots, err := patchUtil.newSnapshotUtil(patch).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Revert != `{"spec":{"replicas":1}}` {
+		t.Fatalf("expected the snapshots of the status to be saved, got %v", snapshots)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	exitCode := func(code int32) *int32 {
+		return &code
+	}
+	tests := []struct {
+		name        string
+		retryPolicy *patchv1alpha1.PatchSpecRetryPolicy
+		attempts    int32
+		exitCode    *int32
+		backoff     time.Duration
+		jitter      time.Duration
+		retry       bool
+		invalid     bool
+	}{
+		{
+			name:     "no retry policy",
+			attempts: 1,
+		},
+		{
+			name:        "first retry",
+			retryPolicy: &patchv1alpha1.PatchSpecRetryPolicy{MaxAttempts: 3},
+			attempts:    1,
+			backoff:     10 * time.Second,
+			retry:       true,
+		},
+		{
+			name:        "doubled backoff",
+			retryPolicy: &patchv1alpha1.PatchSpecRetryPolicy{MaxAttempts: 5, BackoffBase: "1s"},
+			attempts:    3,
+			backoff:     4 * time.Second,
+			retry:       true,
+		},
+		{
+			name:        "maximum backoff",
+			retryPolicy: &patchv1alpha1.PatchSpecRetryPolicy{BackoffBase: "1m", BackoffMax: "5m"},
+			attempts:    10,
+			backoff:     5 * time.Minute,
+			retry:       true,
+		},
+		{
+			name:        "attempts exhausted",
+			retryPolicy: &patchv1alpha1.PatchSpecRetryPolicy{MaxAttempts: 3},
+			attempts:    3,
+		},
+		{
+			name:        "retryable exit code",
+			retryPolicy: &patchv1alpha1.PatchSpecRetryPolicy{RetryOnExitCodes: []int32{1, 137}},
+			attempts:    1,
+			exitCode:    exitCode(137),
+			backoff:     10 * time.Second,
+			retry:       true,
+		},
+		{
+			name:        "other exit code",
+			retryPolicy: &patchv1alpha1.PatchSpecRetryPolicy{RetryOnExitCodes: []int32{1, 137}},
+			attempts:    1,
+			exitCode:    exitCode(2),
+		},
+		{
+			name:        "jitter",
+			retryPolicy: &patchv1alpha1.PatchSpecRetryPolicy{BackoffBase: "10s", Jitter: 20},
+			attempts:    1,
+			backoff:     10 * time.Second,
+			jitter:      2 * time.Second,
+			retry:       true,
+		},
+		{
+			name:        "invalid backoff base",
+			retryPolicy: &patchv1alpha1.PatchSpecRetryPolicy{BackoffBase: "10"},
+			attempts:    1,
+			invalid:     true,
+		},
+		{
+			name:        "invalid backoff max",
+			retryPolicy: &patchv1alpha1.PatchSpecRetryPolicy{BackoffMax: "forever"},
+			attempts:    1,
+			invalid:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := &patchv1alpha1.Patch{
+				Spec:   patchv1alpha1.PatchSpec{RetryPolicy: test.retryPolicy},
+				Status: patchv1alpha1.PatchStatus{Attempts: test.attempts},
+			}
+			backoff, retry, err := (&PatchUtil{}).retryBackoff(patch, test.exitCode)
+			if test.invalid {
+				if err == nil {
+					t.Fatal("expected the retry policy to be invalid")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if retry != test.retry {
+				t.Fatalf("expected retry %t, got %t", test.retry, retry)
+			}
+			if backoff < test.backoff-test.jitter || backoff > test.backoff+test.jitter {
+				t.Fatalf("expected a backoff of %s ± %s, got %s", test.backoff, test.jitter, backoff)
+			}
+		})
+	}
+}
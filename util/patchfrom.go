@@ -0,0 +1,121 @@
+/**
+ * File: /patchfrom.go
+ * Project: util
+ * File Created: 17-10-2026 13:12:41
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PatchFromIndexField indexes patches by the configmaps and secrets they read
+// their patches from, so they are patched again when the content changes
+const PatchFromIndexField = "spec.patches.patchFrom"
+
+const (
+	ConfigMapKind = "ConfigMap"
+	SecretKind    = "Secret"
+)
+
+// AllowCrossNamespaceRefs returns true if patches may read their patches from
+// configmaps and secrets in other namespaces
+func AllowCrossNamespaceRefs() bool {
+	return os.Getenv("ALLOW_CROSS_NAMESPACE_REFS") == "true"
+}
+
+func PatchFromIndexKey(kind string, name string, namespace string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
+
+func IndexPatchFrom(obj client.Object) []string {
+	patch, ok := AsPatch(obj)
+	if !ok {
+		return nil
+	}
+	keys := []string{}
+	for _, patchItem := range patch.Spec.Patches {
+		kind, keyRef := PatchFromKeyRef(&patchItem)
+		if keyRef == nil {
+			continue
+		}
+		keys = append(keys, PatchFromIndexKey(kind, keyRef.Name, Default(keyRef.Namespace, patch.GetNamespace())))
+	}
+	return keys
+}
+
+// HasPatchFrom returns true if any of the patches are read from a configmap or secret
+func HasPatchFrom(patch *patchv1alpha1.Patch) bool {
+	for _, patchItem := range patch.Spec.Patches {
+		if _, keyRef := PatchFromKeyRef(&patchItem); keyRef != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// PatchFromKeyRef returns the kind and key reference the patch is read from or
+// nil if the patch is inline
+func PatchFromKeyRef(patchItem *patchv1alpha1.PatchSpecPatch) (string, *patchv1alpha1.KeyRef) {
+	if patchItem.PatchFrom == nil {
+		return "", nil
+	}
+	if patchItem.PatchFrom.ConfigMapKeyRef != nil {
+		return ConfigMapKind, patchItem.PatchFrom.ConfigMapKeyRef
+	}
+	if patchItem.PatchFrom.SecretKeyRef != nil {
+		return SecretKind, patchItem.PatchFrom.SecretKeyRef
+	}
+	return "", nil
+}
+
+// IsSecretPatch returns true if the patch is read from a secret or any of its
+// vars are read from a secret, so the rendered patch must not be logged
+func IsSecretPatch(patchItem *patchv1alpha1.PatchSpecPatch) bool {
+	if kind, _ := PatchFromKeyRef(patchItem); kind == SecretKind {
+		return true
+	}
+	for _, patchVar := range patchItem.Vars {
+		target := patchVar.Target
+		if target.Kind == SecretKind &&
+			(target.ApiVersion == "v1" || (target.ApiVersion == "" && target.Group == "")) {
+			return true
+		}
+	}
+	return false
+}
+
+// PatchFromNamespace returns the namespace of a key reference
+func PatchFromNamespace(patch *patchv1alpha1.Patch, keyRef *patchv1alpha1.KeyRef) (string, error) {
+	if keyRef.Namespace == "" || keyRef.Namespace == patch.GetNamespace() {
+		return patch.GetNamespace(), nil
+	}
+	if !AllowCrossNamespaceRefs() {
+		return "", errors.New(fmt.Sprintf(
+			"cannot read %s from namespace %s because cross namespace references are not allowed",
+			keyRef.Name, keyRef.Namespace,
+		))
+	}
+	return keyRef.Namespace, nil
+}
@@ -0,0 +1,225 @@
+/**
+ * File: /runner.go
+ * Project: util
+ * File Created: 17-10-2026 21:14:08
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// RunnerCommand is the subcommand of the operator that runs patches in a job
+const RunnerCommand = "runner"
+
+// RunnerPatchKey is the key of the patch in the secret mounted by the runner
+const RunnerPatchKey = "patch.json"
+
+// RunnerResultsPrefix starts the line of the logs with the results of the
+// runner
+const RunnerResultsPrefix = "patch-operator results: "
+
+// RunnerWaitInterval is how often the runner checks whether a target it waits
+// for exists
+const RunnerWaitInterval = time.Duration(time.Second * 5)
+
+// RunnerResult is the result of a patch applied to a target by the runner
+type RunnerResult struct {
+	Id              string                   `json:"id"`
+	ApiVersion      string                   `json:"apiVersion"`
+	Kind            string                   `json:"kind"`
+	Name            string                   `json:"name"`
+	Namespace       string                   `json:"namespace,omitempty"`
+	State           patchv1alpha1.PatchState `json:"state"`
+	Reason          string                   `json:"reason,omitempty"`
+	ResourceVersion string                   `json:"resourceVersion,omitempty"`
+	Error           string                   `json:"error,omitempty"`
+}
+
+// RunnerUtil applies patches from a job with the operator image instead of
+// running a script with kubectl. it runs as the service account of the job
+type RunnerUtil struct {
+	ctx        *context.Context
+	engineUtil *EngineUtil
+	patch      *patchv1alpha1.Patch
+}
+
+func NewRunnerUtil(patch *patchv1alpha1.Patch, kubectlUtil *KubectlUtil, ctx *context.Context) *RunnerUtil {
+	return &RunnerUtil{
+		ctx:        ctx,
+		engineUtil: newEngineUtil(patch, kubectlUtil, ctx),
+		patch:      patch,
+	}
+}
+
+// RunRunner runs the patch mounted in the job and writes the results to the
+// last line of the logs, which the operator reads when the job completes. the
+// results are also written to the termination log, but kubernetes truncates
+// the termination message to 4096 bytes
+func RunRunner(args []string) error {
+	flags := flag.NewFlagSet(RunnerCommand, flag.ContinueOnError)
+	patchPath := flags.String("patch", filepath.Join(JobSecretDir, RunnerPatchKey), "The file with the patch to run.")
+	terminationLogPath := flags.String("termination-log", "/dev/termination-log", "The file the results are written to.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(*patchPath)
+	if err != nil {
+		return err
+	}
+	patch := &patchv1alpha1.Patch{}
+	if err := json.Unmarshal(data, patch); err != nil {
+		return err
+	}
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return err
+	}
+	kubectlUtil, err := NewKubectlUtilForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	ctx := ctrl.SetupSignalHandler()
+	results, runErr := NewRunnerUtil(patch, kubectlUtil, &ctx).Run()
+	body, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s%s\n", RunnerResultsPrefix, body)
+	if err := ioutil.WriteFile(*terminationLogPath, body, 0644); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// ParseRunnerResults parses the results the runner wrote to the termination
+// log. the results are nil if the job did not run the runner or the
+// termination message was truncated
+func ParseRunnerResults(terminationMessage string) []RunnerResult {
+	if !strings.HasPrefix(terminationMessage, "[") {
+		return nil
+	}
+	results := []RunnerResult{}
+	if err := json.Unmarshal([]byte(terminationMessage), &results); err != nil {
+		return nil
+	}
+	return results
+}
+
+// SplitRunnerResults parses the results the runner wrote to the logs and
+// returns the logs without the line of the results, so the results are not
+// saved twice. the results are nil if the logs have no results
+func SplitRunnerResults(logs string) ([]RunnerResult, string) {
+	lines := strings.Split(logs, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if !strings.HasPrefix(lines[i], RunnerResultsPrefix) {
+			continue
+		}
+		results := ParseRunnerResults(strings.TrimPrefix(lines[i], RunnerResultsPrefix))
+		return results, strings.Join(append(lines[:i:i], lines[i+1:]...), "\n")
+	}
+	return nil, logs
+}
+
+// Run applies the patches in order and returns their results. it stops at
+// the first patch that fails
+func (r *RunnerUtil) Run() ([]RunnerResult, error) {
+	results := []RunnerResult{}
+	for i := range r.patch.Spec.Patches {
+		patchItem := &r.patch.Spec.Patches[i]
+		patchId := Default(patchItem.Id, fmt.Sprint(i))
+		resource, err := r.engineUtil.kubectlUtil.TargetToResource(patchId, r.patch, &patchItem.Target)
+		if err != nil {
+			return results, err
+		}
+		result := RunnerResult{
+			Id:         patchId,
+			ApiVersion: resource.GetAPIVersion(),
+			Kind:       resource.GetKind(),
+			Name:       resource.GetName(),
+			Namespace:  resource.GetNamespace(),
+		}
+		fmt.Printf("===== applying patch %s to %s %s =====\n", patchId, result.Kind, result.Name)
+		if patchItem.WaitForTimeout > 0 {
+			fmt.Printf("waiting %d seconds\n", patchItem.WaitForTimeout)
+			select {
+			case <-time.After(time.Duration(patchItem.WaitForTimeout) * time.Second):
+			case <-(*r.ctx).Done():
+				return append(results, r.failed(result, (*r.ctx).Err())), (*r.ctx).Err()
+			}
+		}
+		if patchItem.WaitForResource {
+			if err := r.wait(patchId, &patchItem.Target); err != nil {
+				return append(results, r.failed(result, err)), err
+			}
+		}
+		patched, reason, err := r.engineUtil.Apply(patchId, patchItem)
+		if err != nil {
+			return append(results, r.failed(result, err)), err
+		}
+		if patched == nil {
+			fmt.Printf("skipping patch %s: %s\n", patchId, reason)
+			result.State = patchv1alpha1.SkippedPatchState
+			result.Reason = reason
+		} else {
+			fmt.Printf("patched %s %s\n", result.Kind, result.Name)
+			result.State = patchv1alpha1.AppliedPatchState
+			result.ResourceVersion = patched.GetResourceVersion()
+		}
+		results = append(results, result)
+		fmt.Printf("===== done applying patch %s =====\n\n", patchId)
+	}
+	return results, nil
+}
+
+// wait waits until the target of a patch exists
+func (r *RunnerUtil) wait(patchId string, target *patchv1alpha1.Target) error {
+	for {
+		obj, err := r.engineUtil.get(patchId, target)
+		if err != nil {
+			return err
+		}
+		if obj != nil {
+			return nil
+		}
+		fmt.Printf("waiting for %s %s\n", target.Kind, target.Name)
+		select {
+		case <-time.After(RunnerWaitInterval):
+		case <-(*r.ctx).Done():
+			return (*r.ctx).Err()
+		}
+	}
+}
+
+func (r *RunnerUtil) failed(result RunnerResult, err error) RunnerResult {
+	fmt.Println(err.Error())
+	result.State = patchv1alpha1.FailedPatchState
+	result.Error = err.Error()
+	return result
+}
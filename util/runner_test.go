@@ -0,0 +1,137 @@
+/**
+ * File: /runner_test.go
+ * Project: util
+ * File Created: 17-10-2026 06:55:11
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"strings"
+	"testing"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+)
+
+func TestParseRunnerResults(t *testing.T) {
+	applied := `[{"id":"replicas","apiVersion":"apps/v1","kind":"Deployment","name":"web","namespace":"default","state":"Applied","resourceVersion":"48213"},` +
+		`{"id":"1","apiVersion":"v1","kind":"ConfigMap","name":"web","state":"Skipped","reason":"already patched"}]`
+	tests := []struct {
+		name    string
+		message string
+		ids     []string
+		states  []patchv1alpha1.PatchState
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:    "script output",
+			message: "deployment.apps/web patched",
+		},
+		{
+			name:    "no targets",
+			message: "[]",
+			ids:     []string{},
+			states:  []patchv1alpha1.PatchState{},
+		},
+		{
+			name:    "results",
+			message: applied,
+			ids:     []string{"replicas", "1"},
+			states:  []patchv1alpha1.PatchState{patchv1alpha1.AppliedPatchState, patchv1alpha1.SkippedPatchState},
+		},
+		{
+			name:    "truncated",
+			message: applied[:len(applied)/2],
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results := ParseRunnerResults(test.message)
+			if test.ids == nil {
+				if results != nil {
+					t.Fatalf("expected no results, got %v", results)
+				}
+				return
+			}
+			ids := []string{}
+			states := []patchv1alpha1.PatchState{}
+			for _, result := range results {
+				ids = append(ids, result.Id)
+				states = append(states, result.State)
+			}
+			if strings.Join(ids, ",") != strings.Join(test.ids, ",") {
+				t.Fatalf("expected results for %v, got %v", test.ids, ids)
+			}
+			for i := range states {
+				if states[i] != test.states[i] {
+					t.Fatalf("expected states %v, got %v", test.states, states)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitRunnerResults(t *testing.T) {
+	results := RunnerResultsPrefix + `[{"id":"replicas","apiVersion":"apps/v1","kind":"Deployment","name":"web","state":"Applied"}]`
+	tests := []struct {
+		name string
+		logs string
+		ids  []string
+		rest string
+	}{
+		{
+			name: "script logs",
+			logs: "deployment.apps/web patched\n",
+			rest: "deployment.apps/web patched\n",
+		},
+		{
+			name: "runner logs",
+			logs: "===== applying patch replicas to Deployment web =====\npatched Deployment web\n" + results + "\n",
+			ids:  []string{"replicas"},
+			rest: "===== applying patch replicas to Deployment web =====\npatched Deployment web\n",
+		},
+		{
+			name: "results beyond the termination message limit",
+			logs: RunnerResultsPrefix + "[" + strings.Repeat(`{"id":"replicas","apiVersion":"apps/v1","kind":"Deployment","name":"web","state":"Applied"},`, 100) +
+				`{"id":"last","apiVersion":"apps/v1","kind":"Deployment","name":"web","state":"Applied"}]`,
+			ids: append(strings.Split(strings.Repeat("replicas,", 100), ",")[:100], "last"),
+		},
+		{
+			name: "truncated results",
+			logs: results[:len(results)/2],
+			rest: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, rest := SplitRunnerResults(test.logs)
+			ids := []string{}
+			for _, result := range results {
+				ids = append(ids, result.Id)
+			}
+			if strings.Join(ids, ",") != strings.Join(test.ids, ",") || (results == nil) != (test.ids == nil) {
+				t.Fatalf("expected results for %v, got %v", test.ids, ids)
+			}
+			if rest != test.rest {
+				t.Fatalf("expected the logs %q without the results, got %q", test.rest, rest)
+			}
+		})
+	}
+}
@@ -22,13 +22,12 @@
 package util
 
 import (
-	"errors"
 	"fmt"
 	"strings"
 
-	"gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
 	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
 )
 
 type ScriptUtil struct {
@@ -42,14 +41,12 @@ func NewScriptUtil(patch *patchv1alpha1.Patch) *ScriptUtil {
 echo ===== initializing =====
 echo ----- command -----
 echo 'kubectl get pods -n %s \'
-echo '    -l job-name=%s \'
 echo '    -l %s=%s \'
 echo '    --field-selector status.phase=Succeeded \'
 echo '    -o yaml | kubectl delete -f -'
 echo mkdir -p /tmp/patches
 echo ----- output -----
 kubectl get pods -n %s \
-    -l job-name=%s \
     -l %s=%s \
     --field-selector status.phase=Succeeded \
     -o yaml | kubectl delete -f -
@@ -58,8 +55,8 @@ echo -e "===== done initializing =====\n\n\n"
 
 
 
-`, patch.GetNamespace(), patch.GetName(), PatchLabel, patch.GetName(),
-			patch.GetNamespace(), patch.GetName(), PatchLabel, patch.GetName(),
+`, patch.GetNamespace(), PatchLabel, patch.GetName(),
+			patch.GetNamespace(), PatchLabel, patch.GetName(),
 		),
 		patch: patch,
 	}
@@ -153,29 +150,69 @@ EOF
 `, jsonPath, regex, skipIfResource.GetAPIVersion(), skipIfResource.GetKind(), skipIfResource.GetName(), skipIfResource.GetNamespace())
 		}
 	}
+	patchPreview := patchItem.Patch
+	if IsSecretPatch(patchItem) {
+		patchPreview = "# redacted"
+	}
 	if patchItem.Type == patchv1alpha1.ScriptPatchType {
 		commandPreview += fmt.Sprintf(`echo 'if [ "$SKIP_PATCH" != "true" ]; then'
     cat <<EOF
 %s
 EOF
-echo fi`, patchItem.Patch)
+echo fi`, patchPreview)
 		commandExecute += fmt.Sprintf(`if [ "$SKIP_PATCH" != "true" ]; then
 %s
 else
     echo skipping patch %s
 fi`, patchItem.Patch, patchId)
+	} else if patchItem.Type == patchv1alpha1.ApplyPatchType {
+		data, err := yaml.YAMLToJSON([]byte(patchItem.Patch))
+		if err != nil {
+			return err
+		}
+		body, err := ApplyPatchBody(resource, data)
+		if err != nil {
+			return err
+		}
+		applyPreview := string(body)
+		if patchPreview != patchItem.Patch {
+			applyPreview = patchPreview
+		}
+		force := ""
+		if s.patch.Spec.Force {
+			force = " --force-conflicts"
+		}
+		fieldManager := Default(s.patch.Spec.FieldManager, DefaultFieldManager)
+		commandPreview += fmt.Sprintf(`echo 'if [ "$SKIP_PATCH" != "true" ]; then'
+echo '    kubectl apply --server-side --field-manager %s%s -f /tmp/patches/%s.json'
+cat <<EOF
+%s
+EOF
+echo fi`, fieldManager, force, patchId, applyPreview)
+		commandExecute += fmt.Sprintf(`if [ "$SKIP_PATCH" != "true" ]; then
+    cat <<EOF > /tmp/patches/%s.json
+%s
+EOF
+    kubectl apply --server-side --field-manager %s%s -f /tmp/patches/%s.json
+    [ "$(echo $?)" = "0" ] || exit $?
+else
+    echo skipping patch %s
+fi`, patchId, string(body), fieldManager, force, patchId, patchId)
 	} else {
 		patchType := ""
 		if patchItem.Type != "" {
 			patchType = " --type " + string(patchItem.Type)
 		}
+		if s.patch.Spec.FieldManager != "" {
+			patchType += " --field-manager " + s.patch.Spec.FieldManager
+		}
 		commandPreview += fmt.Sprintf(`echo 'if [ "$SKIP_PATCH" != "true" ]; then'
 		echo '    cat <<EOF | kubectl patch%s --patch-file /tmp/patches/%s.yaml'
 cat <<EOF
 %s
 EOF
 echo EOF
-echo fi`, patchType, patchId, patchItem.Patch)
+echo fi`, patchType, patchId, patchPreview)
 		commandExecute += fmt.Sprintf(`if [ "$SKIP_PATCH" != "true" ]; then
     cat <<EOF > /tmp/patches/%s.yaml
 %s
@@ -213,42 +250,19 @@ func (s *ScriptUtil) Get() string {
 echo ===== finalizing =====
 echo ----- command -----
 echo 'kubectl get pods -n %s \'
-echo '    -l job-name=%s \'
 echo '    -l %s=%s \'
 echo '    --field-selector status.phase=Failed \'
 echo '    -o yaml | kubectl delete -f -'
 echo ----- output -----
 kubectl get pods -n %s \
-    -l job-name=%s \
     -l %s=%s \
     --field-selector status.phase=Failed \
     -o yaml | kubectl delete -f -
 echo -e "===== done finalizing ====="
-`, s.patch.GetNamespace(), s.patch.GetName(), PatchLabel, s.patch.GetName(),
-		s.patch.GetNamespace(), s.patch.GetName(), PatchLabel, s.patch.GetName())
+`, s.patch.GetNamespace(), PatchLabel, s.patch.GetName(),
+		s.patch.GetNamespace(), PatchLabel, s.patch.GetName())
 }
 
-func (s *ScriptUtil) targetToResource(patchId string, patch *v1alpha1.Patch, target *v1alpha1.Target) (*unstructured.Unstructured, error) {
-	resource := unstructured.Unstructured{}
-	apiVersion := target.ApiVersion
-	if apiVersion == "" && target.Version != "" {
-		if target.Group != "" {
-			apiVersion = target.Group + "/"
-		}
-		apiVersion += target.Version
-	}
-	if apiVersion == "" {
-		return nil, errors.New(fmt.Sprintf("apiVersion missing in patch %s", patchId))
-	}
-	kind := target.Kind
-	name := target.Name
-	namespace := target.Namespace
-	if namespace == "" {
-		namespace = patch.GetNamespace()
-	}
-	resource.SetAPIVersion(apiVersion)
-	resource.SetKind(kind)
-	resource.SetName(name)
-	resource.SetNamespace(namespace)
-	return &resource, nil
+func (s *ScriptUtil) targetToResource(patchId string, patch *patchv1alpha1.Patch, target *patchv1alpha1.Target) (*unstructured.Unstructured, error) {
+	return TargetToResource(patchId, patch, target)
 }
@@ -0,0 +1,123 @@
+/**
+ * File: /script_test.go
+ * Project: util
+ * File Created: 17-10-2026 07:14:07
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"strings"
+	"testing"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScriptCleanupSelector(t *testing.T) {
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+	}
+	script := NewScriptUtil(patch).Get()
+	if strings.Contains(script, "job-name=") {
+		t.Fatal("expected pods not to be selected by job name, the job of a run is not named after the patch")
+	}
+	selector := "-l " + PatchLabel + "=web \\"
+	if count := strings.Count(script, "\n    "+selector+"\n"); count != 2 {
+		t.Fatalf("expected the pods to be selected by %s twice, got %d", selector, count)
+	}
+}
+
+func TestScriptRedactsSecretPatches(t *testing.T) {
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+	}
+	target := patchv1alpha1.Target{ApiVersion: "v1", Kind: "ConfigMap", Name: "settings"}
+	tests := []struct {
+		name     string
+		item     patchv1alpha1.PatchSpecPatch
+		redacted bool
+	}{
+		{
+			name: "inline patch",
+			item: patchv1alpha1.PatchSpecPatch{Type: patchv1alpha1.MergePatchType},
+		},
+		{
+			name: "patch from secret",
+			item: patchv1alpha1.PatchSpecPatch{
+				Type:      patchv1alpha1.MergePatchType,
+				PatchFrom: &patchv1alpha1.PatchSpecPatchFrom{SecretKeyRef: &patchv1alpha1.KeyRef{Name: "credentials", Key: "patch.yaml"}},
+			},
+			redacted: true,
+		},
+		{
+			name: "var from secret",
+			item: patchv1alpha1.PatchSpecPatch{
+				Type: patchv1alpha1.MergePatchType,
+				Vars: []patchv1alpha1.PatchSpecPatchVar{{
+					Name:     "password",
+					Target:   patchv1alpha1.Target{ApiVersion: "v1", Kind: "Secret", Name: "credentials"},
+					JsonPath: ".data.password",
+				}},
+			},
+			redacted: true,
+		},
+		{
+			name: "apply patch with var from secret",
+			item: patchv1alpha1.PatchSpecPatch{
+				Type: patchv1alpha1.ApplyPatchType,
+				Vars: []patchv1alpha1.PatchSpecPatchVar{{
+					Name:     "password",
+					Target:   patchv1alpha1.Target{Version: "v1", Kind: "Secret", Name: "credentials"},
+					JsonPath: ".data.password",
+				}},
+			},
+			redacted: true,
+		},
+		{
+			name: "var from configmap",
+			item: patchv1alpha1.PatchSpecPatch{
+				Type: patchv1alpha1.MergePatchType,
+				Vars: []patchv1alpha1.PatchSpecPatchVar{{
+					Name:     "password",
+					Target:   patchv1alpha1.Target{ApiVersion: "v1", Kind: "ConfigMap", Name: "credentials"},
+					JsonPath: ".data.password",
+				}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.item.Target = target
+			test.item.Patch = "data:\n  password: hunter2\n"
+			scriptUtil := NewScriptUtil(patch)
+			if err := scriptUtil.AppendPatch("0", &test.item); err != nil {
+				t.Fatal(err)
+			}
+			script := scriptUtil.Get()
+			preview := script[strings.Index(script, "##### patch 0 #####"):]
+			preview = preview[:strings.Index(preview, "echo ----- output -----")]
+			if redacted := !strings.Contains(preview, "hunter2"); redacted != test.redacted {
+				t.Fatalf("expected the preview to be redacted to be %t, got %s", test.redacted, preview)
+			}
+			if test.redacted && !strings.Contains(preview, "# redacted") {
+				t.Fatalf("expected the preview to be marked as redacted, got %s", preview)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+/**
+ * File: /snapshot.go
+ * Project: util
+ * File Created: 17-10-2026 06:58:26
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SnapshotsKey is the key of the snapshots in the secret of a patch
+const SnapshotsKey = "snapshots.json"
+
+// SnapshotUtil stores the snapshots of a patch in a secret owned by the patch.
+// snapshots are kept out of the status, since they grow with the targets and
+// may contain the data of secrets. the secret is read through the clientset,
+// since the cache only has the metadata of secrets
+type SnapshotUtil struct {
+	clientset kubernetes.Interface
+	ctx       *context.Context
+	name      string
+	owner     client.Object
+	patch     *patchv1alpha1.Patch
+	scheme    *runtime.Scheme
+}
+
+func NewSnapshotUtil(
+	patch *patchv1alpha1.Patch,
+	clientset kubernetes.Interface,
+	ctx *context.Context,
+	scheme *runtime.Scheme,
+) *SnapshotUtil {
+	return &SnapshotUtil{
+		clientset: clientset,
+		ctx:       ctx,
+		name:      patch.GetName() + "-patch-snapshots",
+		owner:     patch,
+		patch:     patch,
+		scheme:    scheme,
+	}
+}
+
+// SetOwner sets the resource that owns the secret. secrets of cluster patches
+// are named differently so they do not collide with patches in the operator namespace
+func (s *SnapshotUtil) SetOwner(owner client.Object) {
+	s.owner = owner
+	if _, ok := owner.(*patchv1alpha1.ClusterPatch); ok {
+		s.name = owner.GetName() + "-clusterpatch-snapshots"
+	}
+}
+
+func (s *SnapshotUtil) Name() string {
+	return s.name
+}
+
+// Get returns the snapshots of the patch. patches that took snapshots before
+// they were stored in a secret still have them in the status
+func (s *SnapshotUtil) Get() ([]patchv1alpha1.PatchStatusSnapshot, error) {
+	secret, err := s.clientset.CoreV1().Secrets(s.patch.GetNamespace()).Get(*s.ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			snapshots := []patchv1alpha1.PatchStatusSnapshot{}
+			for _, snapshot := range s.patch.Status.Snapshots {
+				snapshots = append(snapshots, *snapshot.DeepCopy())
+			}
+			return snapshots, nil
+		}
+		return nil, err
+	}
+	snapshots := []patchv1alpha1.PatchStatusSnapshot{}
+	if err := json.Unmarshal(secret.Data[SnapshotsKey], &snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// Save writes the snapshots to the secret of the patch
+func (s *SnapshotUtil) Save(snapshots []patchv1alpha1.PatchStatusSnapshot) error {
+	data, err := json.Marshal(snapshots)
+	if err != nil {
+		return err
+	}
+	secrets := s.clientset.CoreV1().Secrets(s.patch.GetNamespace())
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.name,
+			Namespace: s.patch.GetNamespace(),
+			Labels: map[string]string{
+				PatchLabel: s.patch.GetName(),
+			},
+		},
+		Data: map[string][]byte{
+			SnapshotsKey: data,
+		},
+	}
+	ctrl.SetControllerReference(s.owner, secret, s.scheme)
+	if _, err := secrets.Create(*s.ctx, secret, metav1.CreateOptions{}); err == nil || !k8sErrors.IsAlreadyExists(err) {
+		return err
+	}
+	existing, err := secrets.Get(*s.ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	existing.Data = secret.Data
+	_, err = secrets.Update(*s.ctx, existing, metav1.UpdateOptions{})
+	return err
+}
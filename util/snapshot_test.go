@@ -0,0 +1,91 @@
+/**
+ * File: /snapshot_test.go
+ * Project: util
+ * File Created: 17-10-2026 06:59:25
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSnapshotUtil(t *testing.T) {
+	patch := &patchv1alpha1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "patch-uid"},
+	}
+	tests := []struct {
+		name  string
+		owner *patchv1alpha1.ClusterPatch
+		kind  string
+	}{
+		{
+			name: "patch",
+			kind: "Patch",
+		},
+		{
+			name:  "cluster patch",
+			owner: &patchv1alpha1.ClusterPatch{ObjectMeta: metav1.ObjectMeta{Name: "web", UID: "clusterpatch-uid"}},
+			kind:  "ClusterPatch",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := kubefake.NewSimpleClientset()
+			ctx := context.Background()
+			snapshotUtil := NewSnapshotUtil(patch, clientset, &ctx, newTestScheme(t))
+			if test.owner != nil {
+				snapshotUtil.SetOwner(test.owner)
+			}
+			snapshots, err := snapshotUtil.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(snapshots) != 0 {
+				t.Fatalf("expected no snapshots, got %v", snapshots)
+			}
+			for _, revert := range []string{`{"spec":{"replicas":1}}`, `{"spec":{"replicas":2}}`} {
+				if err := snapshotUtil.Save([]patchv1alpha1.PatchStatusSnapshot{
+					{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web", Revert: revert},
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			snapshots, err = snapshotUtil.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(snapshots) != 1 || snapshots[0].Revert != `{"spec":{"replicas":2}}` {
+				t.Fatalf("expected the last saved snapshots, got %v", snapshots)
+			}
+			secret, err := clientset.CoreV1().Secrets("default").Get(ctx, snapshotUtil.Name(), metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			owner := metav1.GetControllerOf(secret)
+			if owner == nil || owner.Kind != test.kind || owner.Name != "web" {
+				t.Fatalf("expected the secret to be owned by %s web, got %v", test.kind, owner)
+			}
+		})
+	}
+}
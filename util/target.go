@@ -0,0 +1,145 @@
+/**
+ * File: /target.go
+ * Project: util
+ * File Created: 17-10-2026 10:03:27
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TargetIndexField indexes patches by the resources they target. continuous
+// patches are indexed by every target and all patches are indexed by the
+// targets that match multiple resources, so new matches get patched
+const TargetIndexField = "spec.patches.target"
+
+// WildcardTargetName is the indexed name of targets that match multiple resources
+const WildcardTargetName = "*"
+
+// TargetIndexKey identifies a target by group, kind and name. the namespace is
+// left out because cluster scoped targets default to the namespace of the patch
+func TargetIndexKey(groupKind schema.GroupKind, name string) string {
+	return fmt.Sprintf("%s/%s", groupKind.String(), name)
+}
+
+func IndexPatchTargets(obj client.Object) []string {
+	patch, ok := AsPatch(obj)
+	if !ok {
+		return nil
+	}
+	keys := []string{}
+	for i, patchItem := range patch.Spec.Patches {
+		resource, err := TargetToResource(fmt.Sprint(i), patch, &patchItem.Target)
+		if err != nil {
+			continue
+		}
+		if IsMultiTarget(&patchItem.Target) {
+			keys = append(keys, TargetIndexKey(resource.GroupVersionKind().GroupKind(), WildcardTargetName))
+		} else if patch.Spec.ReconcileMode == patchv1alpha1.ContinuousReconcileMode {
+			keys = append(keys, TargetIndexKey(resource.GroupVersionKind().GroupKind(), resource.GetName()))
+		}
+	}
+	return keys
+}
+
+// IsMultiTarget returns true if the target can match more than one resource
+func IsMultiTarget(target *patchv1alpha1.Target) bool {
+	return target.LabelSelector != nil ||
+		target.NamespaceSelector != nil ||
+		target.Name == "" ||
+		strings.ContainsAny(target.Name, "*?[")
+}
+
+// HasMultiTargets returns true if any of the patch targets can match more than one resource
+func HasMultiTargets(patch *patchv1alpha1.Patch) bool {
+	for _, patchItem := range patch.Spec.Patches {
+		if patchItem.Type != patchv1alpha1.ScriptPatchType && IsMultiTarget(&patchItem.Target) {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetMatchesName returns true if the name matches the target name or glob
+func TargetMatchesName(target *patchv1alpha1.Target, name string) bool {
+	if target.Name == "" {
+		return true
+	}
+	matched, err := path.Match(target.Name, name)
+	return err == nil && matched
+}
+
+// TargetMatchesLabels returns true if the labels match the target label selector
+func TargetMatchesLabels(target *patchv1alpha1.Target, objLabels map[string]string) bool {
+	return SelectorMatchesLabels(target.LabelSelector, objLabels)
+}
+
+func SelectorMatchesLabels(labelSelector *metav1.LabelSelector, objLabels map[string]string) bool {
+	if labelSelector == nil {
+		return true
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(objLabels))
+}
+
+// PatchTargetsObject returns true if any of the patch targets is the object
+func PatchTargetsObject(patch *patchv1alpha1.Patch, obj client.Object) bool {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	for i, patchItem := range patch.Spec.Patches {
+		resource, err := TargetToResource(fmt.Sprint(i), patch, &patchItem.Target)
+		if err != nil {
+			continue
+		}
+		if resource.GroupVersionKind().GroupKind() != gvk.GroupKind() {
+			continue
+		}
+		target := &patchItem.Target
+		if !IsMultiTarget(target) {
+			if resource.GetName() != obj.GetName() {
+				continue
+			}
+			if obj.GetNamespace() == "" || obj.GetNamespace() == resource.GetNamespace() {
+				return true
+			}
+			continue
+		}
+		if !TargetMatchesName(target, obj.GetName()) || !TargetMatchesLabels(target, obj.GetLabels()) {
+			continue
+		}
+		// namespace selectors are resolved when the patch is reconciled
+		if obj.GetNamespace() == "" ||
+			(target.Namespace == "" && target.NamespaceSelector != nil) ||
+			obj.GetNamespace() == resource.GetNamespace() {
+			return true
+		}
+	}
+	return false
+}
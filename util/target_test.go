@@ -0,0 +1,207 @@
+/**
+ * File: /target_test.go
+ * Project: util
+ * File Created: 17-10-2026 07:15:50
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"testing"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestIsMultiTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		target patchv1alpha1.Target
+		multi  bool
+	}{
+		{name: "name", target: patchv1alpha1.Target{Name: "web"}},
+		{name: "no name", target: patchv1alpha1.Target{}, multi: true},
+		{name: "star glob", target: patchv1alpha1.Target{Name: "web-*"}, multi: true},
+		{name: "single character glob", target: patchv1alpha1.Target{Name: "web-?"}, multi: true},
+		{name: "character class glob", target: patchv1alpha1.Target{Name: "web-[ab]"}, multi: true},
+		{
+			name: "label selector with a name",
+			target: patchv1alpha1.Target{
+				Name:          "web",
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			},
+			multi: true,
+		},
+		{
+			name: "namespace selector with a name",
+			target: patchv1alpha1.Target{
+				Name:              "web",
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			},
+			multi: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if multi := IsMultiTarget(&test.target); multi != test.multi {
+				t.Fatalf("expected multi target to be %t, got %t", test.multi, multi)
+			}
+		})
+	}
+}
+
+func TestTargetMatchesName(t *testing.T) {
+	tests := []struct {
+		name       string
+		targetName string
+		objName    string
+		matches    bool
+	}{
+		{name: "exact name", targetName: "web", objName: "web", matches: true},
+		{name: "different name", targetName: "web", objName: "api"},
+		{name: "no name", objName: "web", matches: true},
+		{name: "glob match", targetName: "web-*", objName: "web-1", matches: true},
+		{name: "glob with no match", targetName: "web-*", objName: "api-1"},
+		{name: "single character glob", targetName: "web-?", objName: "web-12"},
+		{name: "character class glob", targetName: "web-[ab]", objName: "web-b", matches: true},
+		{name: "invalid glob", targetName: "web-[", objName: "web-["},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := &patchv1alpha1.Target{Name: test.targetName}
+			if matches := TargetMatchesName(target, test.objName); matches != test.matches {
+				t.Fatalf("expected %q to match %q to be %t, got %t", test.targetName, test.objName, test.matches, matches)
+			}
+		})
+	}
+}
+
+func TestPatchTargetsObject(t *testing.T) {
+	deployment := func(namespace string, name string, labels map[string]string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("apps/v1")
+		obj.SetKind("Deployment")
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		obj.SetLabels(labels)
+		return obj
+	}
+	webSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	tests := []struct {
+		name    string
+		target  patchv1alpha1.Target
+		obj     *unstructured.Unstructured
+		targets bool
+	}{
+		{
+			name:    "name",
+			target:  patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+			obj:     deployment("default", "web", nil),
+			targets: true,
+		},
+		{
+			name:   "different kind",
+			target: patchv1alpha1.Target{ApiVersion: "v1", Kind: "Service", Name: "web"},
+			obj:    deployment("default", "web", nil),
+		},
+		{
+			name:    "different version of the kind",
+			target:  patchv1alpha1.Target{ApiVersion: "apps/v1beta1", Kind: "Deployment", Name: "web"},
+			obj:     deployment("default", "web", nil),
+			targets: true,
+		},
+		{
+			name:   "different namespace",
+			target: patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+			obj:    deployment("other", "web", nil),
+		},
+		{
+			name:    "glob",
+			target:  patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web-*"},
+			obj:     deployment("default", "web-1", nil),
+			targets: true,
+		},
+		{
+			name:   "glob with no match",
+			target: patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web-*"},
+			obj:    deployment("default", "api-1", nil),
+		},
+		{
+			name: "selector combined with a name",
+			target: patchv1alpha1.Target{
+				ApiVersion:    "apps/v1",
+				Kind:          "Deployment",
+				Name:          "web",
+				LabelSelector: webSelector,
+			},
+			obj:     deployment("default", "web", map[string]string{"app": "web"}),
+			targets: true,
+		},
+		{
+			name: "selector combined with a name not matching the labels",
+			target: patchv1alpha1.Target{
+				ApiVersion:    "apps/v1",
+				Kind:          "Deployment",
+				Name:          "web",
+				LabelSelector: webSelector,
+			},
+			obj: deployment("default", "web", map[string]string{"app": "api"}),
+		},
+		{
+			name: "selector combined with a name not matching the name",
+			target: patchv1alpha1.Target{
+				ApiVersion:    "apps/v1",
+				Kind:          "Deployment",
+				Name:          "web",
+				LabelSelector: webSelector,
+			},
+			obj: deployment("default", "api", map[string]string{"app": "web"}),
+		},
+		{
+			name: "namespace selector in another namespace",
+			target: patchv1alpha1.Target{
+				ApiVersion:        "apps/v1",
+				Kind:              "Deployment",
+				Name:              "web",
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			},
+			obj:     deployment("other", "web", nil),
+			targets: true,
+		},
+		{
+			name:    "cluster scoped object",
+			target:  patchv1alpha1.Target{ApiVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+			obj:     deployment("", "web", nil),
+			targets: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := &patchv1alpha1.Patch{
+				ObjectMeta: metav1.ObjectMeta{Name: "patch", Namespace: "default"},
+				Spec: patchv1alpha1.PatchSpec{
+					Patches: []patchv1alpha1.PatchSpecPatch{{Target: test.target}},
+				},
+			}
+			if targets := PatchTargetsObject(patch, test.obj); targets != test.targets {
+				t.Fatalf("expected patch to target the object to be %t, got %t", test.targets, targets)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+/**
+ * File: /template.go
+ * Project: util
+ * File Created: 17-10-2026 14:05:19
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the helper functions available when rendering patches
+var templateFuncs = template.FuncMap{
+	"base64": func(value string) string {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	},
+	"toJson": func(value interface{}) (string, error) {
+		body, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(body), nil
+	},
+	"default": func(defaultValue interface{}, value interface{}) interface{} {
+		if value == nil {
+			return defaultValue
+		}
+		if v := reflect.ValueOf(value); v.IsZero() {
+			return defaultValue
+		}
+		return value
+	},
+	"indent": func(spaces int, value string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.Replace(value, "\n", "\n"+pad, -1)
+	},
+}
+
+// RenderPatch renders a patch as a go template with the vars
+func RenderPatch(patchId string, patch string, vars map[string]string) (string, error) {
+	t, err := template.New(patchId).Funcs(templateFuncs).Option("missingkey=error").Parse(patch)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("patch %s template is invalid: %s", patchId, err.Error()))
+	}
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, vars); err != nil {
+		return "", errors.New(fmt.Sprintf("patch %s failed to render: %s", patchId, err.Error()))
+	}
+	return buf.String(), nil
+}
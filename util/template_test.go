@@ -0,0 +1,105 @@
+/**
+ * File: /template_test.go
+ * Project: util
+ * File Created: 17-10-2026 06:59:55
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderPatch(t *testing.T) {
+	vars := map[string]string{
+		"host":     "example.com",
+		"password": "hunter2",
+		"empty":    "",
+		"config":   "a: 1\nb: 2",
+	}
+	tests := []struct {
+		name     string
+		patch    string
+		expected string
+		err      string
+	}{
+		{
+			name:     "no template",
+			patch:    `{"spec":{"replicas":3}}`,
+			expected: `{"spec":{"replicas":3}}`,
+		},
+		{
+			name:     "var",
+			patch:    `{"data":{"host":"{{ .host }}"}}`,
+			expected: `{"data":{"host":"example.com"}}`,
+		},
+		{
+			name:     "base64",
+			patch:    `{"data":{"password":"{{ .password | base64 }}"}}`,
+			expected: `{"data":{"password":"aHVudGVyMg=="}}`,
+		},
+		{
+			name:     "toJson",
+			patch:    `{"data":{"config":{{ toJson .config }}}}`,
+			expected: `{"data":{"config":"a: 1\nb: 2"}}`,
+		},
+		{
+			name:     "default of an empty var",
+			patch:    `{{ default "localhost" .empty }}`,
+			expected: "localhost",
+		},
+		{
+			name:     "default of a set var",
+			patch:    `{{ default "localhost" .host }}`,
+			expected: "example.com",
+		},
+		{
+			name:     "indent",
+			patch:    "config:\n{{ indent 2 .config }}",
+			expected: "config:\n  a: 1\n  b: 2",
+		},
+		{
+			name:  "missing var",
+			patch: `{"data":{"port":"{{ .port }}"}}`,
+			err:   "patch replicas failed to render",
+		},
+		{
+			name:  "invalid template",
+			patch: `{"data":{"host":"{{ .host }"}}`,
+			err:   "patch replicas template is invalid",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rendered, err := RenderPatch("replicas", test.patch, vars)
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rendered != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, rendered)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+/**
+ * File: /validate.go
+ * Project: util
+ * File Created: 17-10-2026 16:48:13
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+// ParseJsonPath parses a json path. the path may leave out the leading dot
+// and the surrounding braces
+func ParseJsonPath(path string) (*jsonpath.JSONPath, error) {
+	if !strings.HasPrefix(path, ".") {
+		path = "." + path
+	}
+	j := jsonpath.New("jsonPath")
+	j.AllowMissingKeys(true)
+	if err := j.Parse("{" + path + "}"); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// ValidatePatchSpec validates the patches of a patch spec without reading
+// anything from the cluster
+func ValidatePatchSpec(spec *patchv1alpha1.PatchSpec) error {
+	messages := []string{}
+	ids := map[string]bool{}
+	for i, patchItem := range spec.Patches {
+		patchId := patchItem.Id
+		if patchId == "" {
+			patchId = fmt.Sprint(i)
+		} else if ids[patchId] {
+			messages = append(messages, fmt.Sprintf("patch id %s is not unique", patchId))
+		}
+		ids[patchId] = true
+		if err := ValidatePatchItem(patchId, &patchItem); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// ValidateClusterPatchSpec validates the fields of a cluster patch spec that
+// are not resolved like the fields of a patch. the service account must be set
+// and dependencies can only be other cluster patches
+func ValidateClusterPatchSpec(spec *patchv1alpha1.PatchSpec) error {
+	messages := []string{}
+	if spec.ServiceAccountName == "" {
+		messages = append(messages, "cluster patch must set serviceAccountName")
+	}
+	for _, dependency := range spec.DependsOn {
+		if dependency.Namespace != "" {
+			messages = append(messages, fmt.Sprintf(
+				"cluster patch dependsOn %s/%s must not set a namespace",
+				dependency.Namespace, dependency.Name,
+			))
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// ValidatePatchItem validates the patch, skip criteria and vars of a patch item
+func ValidatePatchItem(patchId string, patchItem *patchv1alpha1.PatchSpecPatch) error {
+	if patchItem.PatchFrom != nil &&
+		patchItem.PatchFrom.ConfigMapKeyRef != nil &&
+		patchItem.PatchFrom.SecretKeyRef != nil {
+		return errors.New(fmt.Sprintf("patch %s patchFrom must set only one of configMapKeyRef and secretKeyRef", patchId))
+	}
+	if _, keyRef := PatchFromKeyRef(patchItem); keyRef != nil {
+		if patchItem.Patch != "" {
+			return errors.New(fmt.Sprintf("patch %s must set only one of patch and patchFrom", patchId))
+		}
+	} else if patchItem.Patch == "" {
+		return errors.New(fmt.Sprintf("patch %s must set patch or patchFrom", patchId))
+	} else if len(patchItem.Vars) > 0 {
+		if _, err := template.New(patchId).Funcs(templateFuncs).Parse(patchItem.Patch); err != nil {
+			return errors.New(fmt.Sprintf("patch %s template is invalid: %s", patchId, err.Error()))
+		}
+	} else if err := ValidatePatchBody(patchItem.Type, patchItem.Patch); err != nil {
+		return errors.New(fmt.Sprintf("patch %s is invalid: %s", patchId, err.Error()))
+	}
+	for _, skipIf := range patchItem.SkipIf {
+		if skipIf.JsonPath != "" && skipIf.JsonPath != "." {
+			if _, err := ParseJsonPath(skipIf.JsonPath); err != nil {
+				return errors.New(fmt.Sprintf("patch %s skipIf jsonPath %s is invalid: %s", patchId, skipIf.JsonPath, err.Error()))
+			}
+		}
+		if _, err := regexp.Compile(skipIf.Regex); err != nil {
+			return errors.New(fmt.Sprintf("patch %s skipIf regex %s is invalid: %s", patchId, skipIf.Regex, err.Error()))
+		}
+	}
+	for _, patchVar := range patchItem.Vars {
+		if _, err := ParseJsonPath(patchVar.JsonPath); err != nil {
+			return errors.New(fmt.Sprintf("patch %s var %s jsonPath is invalid: %s", patchId, patchVar.Name, err.Error()))
+		}
+	}
+	return nil
+}
+
+// ValidatePatchBody parses a patch according to its type. script patches are
+// not parsed
+func ValidatePatchBody(patchType patchv1alpha1.PatchType, patch string) error {
+	if patchType == patchv1alpha1.ScriptPatchType {
+		return nil
+	}
+	data, err := yaml.YAMLToJSON([]byte(patch))
+	if err != nil {
+		return err
+	}
+	if patchType == patchv1alpha1.JsonPatchType {
+		operations, err := jsonpatch.DecodePatch(data)
+		if err != nil {
+			return errors.New(fmt.Sprintf("json patch must be a list of operations: %s", err.Error()))
+		}
+		for i, operation := range operations {
+			switch operation.Kind() {
+			case "add", "remove", "replace", "move", "copy", "test":
+			default:
+				return errors.New(fmt.Sprintf("json patch operation %d has invalid op %s", i, operation.Kind()))
+			}
+			if _, err := operation.Path(); err != nil {
+				return errors.New(fmt.Sprintf("json patch operation %d is missing path", i))
+			}
+		}
+		return nil
+	}
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return errors.New(fmt.Sprintf("%s patch must be an object", Default(string(patchType), string(patchv1alpha1.StrategicPatchType))))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+/**
+ * File: /validate_test.go
+ * Project: util
+ * File Created: 17-10-2026 06:36:23
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"strings"
+	"testing"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+)
+
+func TestValidateClusterPatchSpec(t *testing.T) {
+	tests := []struct {
+		name string
+		spec patchv1alpha1.PatchSpec
+		err  string
+	}{
+		{
+			name: "valid",
+			spec: patchv1alpha1.PatchSpec{
+				ServiceAccountName: "patch-crds",
+				DependsOn:          []patchv1alpha1.NamespacedName{{Name: "crds"}},
+			},
+		},
+		{
+			name: "service account missing",
+			spec: patchv1alpha1.PatchSpec{},
+			err:  "cluster patch must set serviceAccountName",
+		},
+		{
+			name: "namespaced dependency",
+			spec: patchv1alpha1.PatchSpec{
+				ServiceAccountName: "patch-crds",
+				DependsOn:          []patchv1alpha1.NamespacedName{{Name: "crds", Namespace: "default"}},
+			},
+			err: "cluster patch dependsOn default/crds must not set a namespace",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateClusterPatchSpec(&test.spec)
+			if test.err == "" && err != nil {
+				t.Fatalf("expected spec to be valid, got %s", err.Error())
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
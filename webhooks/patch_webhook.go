@@ -0,0 +1,257 @@
+/**
+ * File: /patch_webhook.go
+ * Project: webhooks
+ * File Created: 17-10-2026 17:02:36
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	"gitlab.com/bitspur/rock8s/patch-operator/util"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	MutatePatchPath          = "/mutate-patch-rock8s-com-v1alpha1-patch"
+	MutateClusterPatchPath   = "/mutate-patch-rock8s-com-v1alpha1-clusterpatch"
+	ValidatePatchPath        = "/validate-patch-rock8s-com-v1alpha1-patch"
+	ValidateClusterPatchPath = "/validate-patch-rock8s-com-v1alpha1-clusterpatch"
+)
+
+//+kubebuilder:webhook:path=/mutate-patch-rock8s-com-v1alpha1-patch,mutating=true,failurePolicy=fail,sideEffects=None,groups=patch.rock8s.com,resources=patches,verbs=create;update,versions=v1alpha1,name=mpatch.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/mutate-patch-rock8s-com-v1alpha1-clusterpatch,mutating=true,failurePolicy=fail,sideEffects=None,groups=patch.rock8s.com,resources=clusterpatches,verbs=create;update,versions=v1alpha1,name=mclusterpatch.kb.io,admissionReviewVersions=v1
+
+// PatchDefaulter persists the defaults of patches and cluster patches when
+// they are created or updated
+type PatchDefaulter struct {
+	restMapper meta.RESTMapper
+	decoder    *admission.Decoder
+}
+
+//+kubebuilder:webhook:path=/validate-patch-rock8s-com-v1alpha1-patch,mutating=false,failurePolicy=fail,sideEffects=None,groups=patch.rock8s.com,resources=patches,verbs=create;update,versions=v1alpha1,name=vpatch.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-patch-rock8s-com-v1alpha1-clusterpatch,mutating=false,failurePolicy=fail,sideEffects=None,groups=patch.rock8s.com,resources=clusterpatches,verbs=create;update,versions=v1alpha1,name=vclusterpatch.kb.io,admissionReviewVersions=v1
+
+// PatchValidator validates patches and cluster patches when they are created
+// or updated
+type PatchValidator struct {
+	readerFor  func(namespace string, serviceAccountName string) (client.Reader, error)
+	restMapper meta.RESTMapper
+	decoder    *admission.Decoder
+}
+
+// SetupPatchWebhookWithManager registers the patch webhooks and the conversion
+// webhook with the manager. targets are read as the service account of the
+// patch, so the operator does not need access to them
+func SetupPatchWebhookWithManager(mgr ctrl.Manager, kubectlUtil *util.KubectlUtil) error {
+	if err := ctrl.NewWebhookManagedBy(mgr).For(&patchv1alpha1.Patch{}).Complete(); err != nil {
+		return err
+	}
+	if err := ctrl.NewWebhookManagedBy(mgr).For(&patchv1alpha1.ClusterPatch{}).Complete(); err != nil {
+		return err
+	}
+	defaulter := &PatchDefaulter{
+		restMapper: mgr.GetRESTMapper(),
+	}
+	mgr.GetWebhookServer().Register(MutatePatchPath, &webhook.Admission{Handler: defaulter})
+	mgr.GetWebhookServer().Register(MutateClusterPatchPath, &webhook.Admission{Handler: defaulter})
+	validator := &PatchValidator{
+		readerFor: func(namespace string, serviceAccountName string) (client.Reader, error) {
+			return kubectlUtil.ForServiceAccount(namespace, serviceAccountName).Reader()
+		},
+		restMapper: mgr.GetRESTMapper(),
+	}
+	mgr.GetWebhookServer().Register(ValidatePatchPath, &webhook.Admission{Handler: validator})
+	mgr.GetWebhookServer().Register(ValidateClusterPatchPath, &webhook.Admission{Handler: validator})
+	return nil
+}
+
+func (d *PatchDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+func (d *PatchDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var obj client.Object
+	if req.Kind.Kind == "ClusterPatch" {
+		clusterPatch := &patchv1alpha1.ClusterPatch{}
+		if err := d.decoder.Decode(req, clusterPatch); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		util.DefaultClusterPatchSpec(&clusterPatch.Spec, util.GetOperatorNamespace(), d.restMapper)
+		obj = clusterPatch
+	} else {
+		patch := &patchv1alpha1.Patch{}
+		if err := d.decoder.Decode(req, patch); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		util.DefaultPatchSpec(&patch.Spec, util.Default(patch.GetNamespace(), req.Namespace), d.restMapper)
+		obj = patch
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, body)
+}
+
+func (v *PatchValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+func (v *PatchValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	patch := &patchv1alpha1.Patch{}
+	if req.Kind.Kind == "ClusterPatch" {
+		clusterPatch := &patchv1alpha1.ClusterPatch{}
+		if err := v.decoder.Decode(req, clusterPatch); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if err := util.ValidateClusterPatchSpec(&clusterPatch.Spec); err != nil {
+			return admission.Denied(err.Error())
+		}
+		patch = util.ClusterPatchToPatch(clusterPatch)
+	} else if err := v.decoder.Decode(req, patch); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	warnings, err := v.validate(ctx, patch)
+	if err != nil {
+		return admission.Denied(err.Error()).WithWarnings(warnings...)
+	}
+	return admission.Allowed("").WithWarnings(warnings...)
+}
+
+// validate returns warnings for targets that do not exist yet or an error if
+// the patch is invalid
+func (v *PatchValidator) validate(ctx context.Context, patch *patchv1alpha1.Patch) ([]string, error) {
+	if err := util.ValidatePatchSpec(&patch.Spec); err != nil {
+		return nil, err
+	}
+	warnings := []string{}
+	messages := []string{}
+	for i, patchItem := range patch.Spec.Patches {
+		if patchItem.Type == patchv1alpha1.ScriptPatchType {
+			continue
+		}
+		patchId := util.Default(patchItem.Id, fmt.Sprint(i))
+		targets := []*patchv1alpha1.Target{&patchItem.Target}
+		for _, skipIf := range patchItem.SkipIf {
+			if skipIf.Target != nil {
+				targets = append(targets, skipIf.Target)
+			}
+		}
+		for j := range patchItem.Vars {
+			targets = append(targets, &patchItem.Vars[j].Target)
+		}
+		for j, target := range targets {
+			warning, err := v.validateTarget(ctx, patchId, patch, target, patchItem.WaitForResource, j == 0)
+			if err != nil {
+				messages = append(messages, err.Error())
+			} else if warning != "" {
+				warnings = append(warnings, warning)
+			}
+		}
+	}
+	if len(messages) > 0 {
+		return warnings, errors.New(strings.Join(messages, "; "))
+	}
+	return warnings, nil
+}
+
+// validateTarget resolves the kind of a target through discovery. only a
+// malformed target is an error. a warning is returned if the kind is not
+// installed, if the patched resource does not exist, or if it could not be
+// read, because the patch may succeed once the kind is installed, the
+// resource is created or the service account is granted access
+func (v *PatchValidator) validateTarget(
+	ctx context.Context,
+	patchId string,
+	patch *patchv1alpha1.Patch,
+	target *patchv1alpha1.Target,
+	waitForResource bool,
+	patched bool,
+) (string, error) {
+	resource, err := util.TargetToResource(patchId, patch, target)
+	if err != nil {
+		return "", err
+	}
+	if resource.GetKind() == "" {
+		return "", errors.New(fmt.Sprintf("kind missing in patch %s", patchId))
+	}
+	gvk := resource.GroupVersionKind()
+	mapping, err := v.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		if !meta.IsNoMatchError(err) {
+			return fmt.Sprintf("patch %s target kind %s could not be resolved: %s", patchId, gvk.String(), err.Error()), nil
+		}
+		if waitForResource {
+			return fmt.Sprintf("patch %s target kind %s is not installed", patchId, gvk.String()), nil
+		}
+		return fmt.Sprintf(
+			"patch %s target kind %s is not installed and the patch will fail unless waitForResource is set",
+			patchId,
+			gvk.String(),
+		), nil
+	}
+	if !patched || util.IsMultiTarget(target) {
+		return "", nil
+	}
+	namespace := resource.GetNamespace()
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		namespace = ""
+	}
+	reader, err := v.readerFor(
+		patch.GetNamespace(),
+		util.Default(patch.Spec.ServiceAccountName, util.GetDefaultServiceAccountName()),
+	)
+	if err != nil {
+		return fmt.Sprintf("patch %s target %s %s could not be read: %s", patchId, gvk.Kind, resource.GetName(), err.Error()), nil
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	if err := reader.Get(ctx, types.NamespacedName{Name: resource.GetName(), Namespace: namespace}, obj); err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return fmt.Sprintf("patch %s target %s %s does not exist", patchId, gvk.Kind, resource.GetName()), nil
+		}
+		if k8sErrors.IsForbidden(err) {
+			return fmt.Sprintf(
+				"patch %s target %s %s is not readable by service account %s",
+				patchId,
+				gvk.Kind,
+				resource.GetName(),
+				util.Default(patch.Spec.ServiceAccountName, util.GetDefaultServiceAccountName()),
+			), nil
+		}
+		return fmt.Sprintf("patch %s target %s %s could not be read: %s", patchId, gvk.Kind, resource.GetName(), err.Error()), nil
+	}
+	return "", nil
+}
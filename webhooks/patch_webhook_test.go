@@ -0,0 +1,227 @@
+/**
+ * File: /patch_webhook_test.go
+ * Project: webhooks
+ * File Created: 17-10-2026 06:34:55
+ * Author: Clay Risser
+ * -----
+ * BitSpur (c) Copyright 2021 - 2026
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	patchv1alpha1 "gitlab.com/bitspur/rock8s/patch-operator/api/v1alpha1"
+	"gitlab.com/bitspur/rock8s/patch-operator/util"
+	appsv1 "k8s.io/api/apps/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// failingReader fails to read every object
+type failingReader struct {
+	client.Reader
+	err error
+}
+
+func (r *failingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return r.err
+}
+
+func newRESTMapper() meta.RESTMapper {
+	restMapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{})
+	restMapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	return restMapper
+}
+
+func TestValidate(t *testing.T) {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	reader := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(deployment).Build()
+	target := func(apiVersion string, kind string, name string) patchv1alpha1.Target {
+		return patchv1alpha1.Target{ApiVersion: apiVersion, Kind: kind, Name: name}
+	}
+	tests := []struct {
+		name     string
+		reader   client.Reader
+		patches  []patchv1alpha1.PatchSpecPatch
+		warnings int
+		warning  string
+		err      string
+	}{
+		{
+			name: "valid",
+			patches: []patchv1alpha1.PatchSpecPatch{{
+				Target: target("apps/v1", "Deployment", "web"),
+				Patch:  "spec:\n  replicas: 3\n",
+			}},
+		},
+		{
+			name: "target does not exist",
+			patches: []patchv1alpha1.PatchSpecPatch{{
+				Target: target("apps/v1", "Deployment", "api"),
+				Patch:  "spec:\n  replicas: 3\n",
+			}},
+			warnings: 1,
+		},
+		{
+			name:   "target could not be read",
+			reader: &failingReader{err: errors.New("connection refused")},
+			patches: []patchv1alpha1.PatchSpecPatch{{
+				Target: target("apps/v1", "Deployment", "web"),
+				Patch:  "spec:\n  replicas: 3\n",
+			}},
+			warnings: 1,
+			warning:  "could not be read: connection refused",
+		},
+		{
+			name: "target is forbidden",
+			reader: &failingReader{
+				err: k8sErrors.NewForbidden(schema.GroupResource{Group: "apps", Resource: "deployments"}, "web", nil),
+			},
+			patches: []patchv1alpha1.PatchSpecPatch{{
+				Target: target("apps/v1", "Deployment", "web"),
+				Patch:  "spec:\n  replicas: 3\n",
+			}},
+			warnings: 1,
+			warning:  "is not readable by service account default",
+		},
+		{
+			name: "kind not installed",
+			patches: []patchv1alpha1.PatchSpecPatch{{
+				Target: target("example.com/v1", "Widget", "web"),
+				Patch:  "spec: {}\n",
+			}},
+			warnings: 1,
+			warning:  "target kind example.com/v1, Kind=Widget is not installed and the patch will fail",
+		},
+		{
+			name: "kind not installed while waiting for resource",
+			patches: []patchv1alpha1.PatchSpecPatch{{
+				Target:          target("example.com/v1", "Widget", "web"),
+				Patch:           "spec: {}\n",
+				WaitForResource: true,
+			}},
+			warnings: 1,
+		},
+		{
+			name: "kind missing",
+			patches: []patchv1alpha1.PatchSpecPatch{{
+				Target: target("v1", "", "web"),
+				Patch:  "data: {}\n",
+			}},
+			err: "kind missing in patch 0",
+		},
+		{
+			name: "invalid json patch",
+			patches: []patchv1alpha1.PatchSpecPatch{{
+				Type:   patchv1alpha1.JsonPatchType,
+				Target: target("v1", "ConfigMap", "settings"),
+				Patch:  "op: add\n",
+			}},
+			err: "patch 0 is invalid",
+		},
+		{
+			name: "duplicate ids",
+			patches: []patchv1alpha1.PatchSpecPatch{
+				{Id: "a", Target: target("v1", "Namespace", "default"), Patch: "metadata: {}\n"},
+				{Id: "a", Target: target("v1", "Namespace", "default"), Patch: "metadata: {}\n"},
+			},
+			err: "patch id a is not unique",
+		},
+		{
+			name: "invalid skip regex",
+			patches: []patchv1alpha1.PatchSpecPatch{{
+				Target: target("apps/v1", "Deployment", "web"),
+				Patch:  "spec: {}\n",
+				SkipIf: []patchv1alpha1.PatchSpecPatchSkipIf{{JsonPath: ".spec.paused", Regex: "("}},
+			}},
+			err: "skipIf regex ( is invalid",
+		},
+		{
+			name: "invalid var template",
+			patches: []patchv1alpha1.PatchSpecPatch{{
+				Target: target("apps/v1", "Deployment", "web"),
+				Patch:  "spec:\n  replicas: {{ .replicas\n",
+				Vars: []patchv1alpha1.PatchSpecPatchVar{{
+					Name:     "replicas",
+					Target:   target("v1", "ConfigMap", "settings"),
+					JsonPath: ".data.replicas",
+				}},
+			}},
+			err: "template is invalid",
+		},
+		{
+			name: "invalid skip json path",
+			patches: []patchv1alpha1.PatchSpecPatch{{
+				Target: target("apps/v1", "Deployment", "web"),
+				Patch:  "spec: {}\n",
+				SkipIf: []patchv1alpha1.PatchSpecPatchSkipIf{{JsonPath: ".spec[", Regex: "true"}},
+			}},
+			err: "skipIf jsonPath .spec[ is invalid",
+		},
+		{
+			name: "script is not resolved",
+			patches: []patchv1alpha1.PatchSpecPatch{{
+				Type:   patchv1alpha1.ScriptPatchType,
+				Target: target("example.com/v1", "Widget", "web"),
+				Patch:  "kubectl get widgets\n",
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validator := &PatchValidator{
+				readerFor: func(namespace string, serviceAccountName string) (client.Reader, error) {
+					if namespace != "default" || serviceAccountName != util.DefaultServiceAccountName {
+						t.Fatalf("expected targets to be read as default/default, got %s/%s", namespace, serviceAccountName)
+					}
+					if test.reader != nil {
+						return test.reader, nil
+					}
+					return reader, nil
+				},
+				restMapper: newRESTMapper(),
+			}
+			patch := &patchv1alpha1.Patch{
+				ObjectMeta: metav1.ObjectMeta{Name: "patch", Namespace: "default"},
+				Spec:       patchv1alpha1.PatchSpec{Patches: test.patches},
+			}
+			warnings, err := validator.validate(context.Background(), patch)
+			if test.err == "" && err != nil {
+				t.Fatalf("expected patch to be valid, got %s", err.Error())
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+			if len(warnings) != test.warnings {
+				t.Fatalf("expected %d warnings, got %v", test.warnings, warnings)
+			}
+			if test.warning != "" && !strings.Contains(strings.Join(warnings, "; "), test.warning) {
+				t.Fatalf("expected warning containing %q, got %v", test.warning, warnings)
+			}
+		})
+	}
+}